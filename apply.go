@@ -0,0 +1,266 @@
+package mark
+
+import "fmt"
+
+// ApplyFunc is called by Apply for each node, see Apply
+type ApplyFunc func(c *Cursor) bool
+
+// Apply traverses nodes in depth-first order, see Walk for the order of
+// children, and calls pre and post for each node. Either can be nil.
+//
+// When pre returns false, the children and post of the node are skipped.
+// When post returns false, the traversal stops and Apply returns.
+//
+// The nodes can be replaced, removed and inserted using the Cursor.
+// The tree is modified in place, the result is the root, which is
+// different from root when it was replaced. The root cannot be deleted.
+func Apply(root Node, pre, post ApplyFunc) (result Node) {
+	a := &application{pre: pre, post: post}
+	c := &Cursor{node: root, index: -1, name: "Root"}
+	return a.apply(c)[0]
+}
+
+// Cursor describes a node encountered during Apply
+type Cursor struct {
+	parent Node
+	name   string
+	index  int // -1 when not in a slice
+	node   Node
+
+	deleted bool
+	before  []Node
+	after   []Node
+}
+
+// Node returns the current node
+func (c *Cursor) Node() Node { return c.node }
+
+// Parent returns the parent of the current node. Parents that are inlines
+// are values, they don't contain the changes of the children.
+func (c *Cursor) Parent() Node { return c.parent }
+
+// Name returns the name of the parent field that contains the current node,
+// e.g. "Content" or "Title".
+func (c *Cursor) Name() string { return c.name }
+
+// Index returns the index of the current node in the slice of the parent
+// before any changes, -1 when the node is not in a slice.
+func (c *Cursor) Index() int { return c.index }
+
+// Replace replaces the current node with node, the children of node are
+// traversed instead. The type must fit the parent field, e.g. a Block
+// in Content or a *Paragraph in Title.
+func (c *Cursor) Replace(node Node) {
+	if node == nil {
+		panic("mark.Cursor.Replace: nil node")
+	}
+	c.node = node
+}
+
+// Delete removes the current node from the slice of the parent
+func (c *Cursor) Delete() {
+	c.mustSlice("Delete")
+	c.deleted = true
+}
+
+// InsertBefore inserts node before the current node,
+// Apply does not traverse node.
+func (c *Cursor) InsertBefore(node Node) {
+	c.mustSlice("InsertBefore")
+	c.before = append(c.before, node)
+}
+
+// InsertAfter inserts node after the current node and nodes inserted
+// earlier, Apply does not traverse node.
+func (c *Cursor) InsertAfter(node Node) {
+	c.mustSlice("InsertAfter")
+	c.after = append(c.after, node)
+}
+
+func (c *Cursor) mustSlice(method string) {
+	if c.index < 0 {
+		panic(fmt.Sprintf("mark.Cursor.%s: %s is not a slice", method, c.name))
+	}
+}
+
+type application struct {
+	pre, post ApplyFunc
+	stopped   bool
+}
+
+// apply handles a single node and returns the nodes that replace it
+func (a *application) apply(c *Cursor) []Node {
+	if a.stopped {
+		return []Node{c.node}
+	}
+
+	if a.pre == nil || a.pre(c) {
+		if !c.deleted {
+			c.node = a.children(c.node)
+			if !a.stopped && a.post != nil && !a.post(c) {
+				a.stopped = true
+			}
+		}
+	}
+
+	nodes := append([]Node{}, c.before...)
+	if !c.deleted {
+		nodes = append(nodes, c.node)
+	}
+	return append(nodes, c.after...)
+}
+
+// list applies to each node in a slice of parent
+func (a *application) list(parent Node, name string, nodes []Node) []Node {
+	var result []Node
+	for i, node := range nodes {
+		result = append(result, a.apply(&Cursor{parent: parent, name: name, index: i, node: node})...)
+	}
+	return result
+}
+
+// field applies to a node that is not in a slice
+func (a *application) field(parent Node, name string, node Node) Node {
+	return a.apply(&Cursor{parent: parent, name: name, index: -1, node: node})[0]
+}
+
+// paragraph applies to a Paragraph field
+func (a *application) paragraph(parent Node, name string, p *Paragraph) {
+	switch node := a.field(parent, name, p).(type) {
+	case *Paragraph:
+		*p = *node
+	case Paragraph:
+		*p = node
+	default:
+		panic(fmt.Sprintf("mark.Apply: cannot use %T as %s", node, name))
+	}
+}
+
+func (a *application) blocks(parent Node, name string, seq Sequence) Sequence {
+	nodes := make([]Node, len(seq))
+	for i, block := range seq {
+		nodes[i] = block
+	}
+
+	var result Sequence
+	for _, node := range a.list(parent, name, nodes) {
+		block, ok := node.(Block)
+		if !ok {
+			panic(fmt.Sprintf("mark.Apply: cannot use %T as Block in %s", node, name))
+		}
+		result = append(result, block)
+	}
+	return result
+}
+
+func (a *application) inlines(parent Node, name string, items []Inline) []Inline {
+	nodes := make([]Node, len(items))
+	for i, item := range items {
+		nodes[i] = item
+	}
+
+	var result []Inline
+	for _, node := range a.list(parent, name, nodes) {
+		item, ok := node.(Inline)
+		if !ok {
+			panic(fmt.Sprintf("mark.Apply: cannot use %T as Inline in %s", node, name))
+		}
+		result = append(result, item)
+	}
+	return result
+}
+
+func (a *application) items(list *List) []Sequence {
+	nodes := make([]Node, len(list.Content))
+	for i := range list.Content {
+		nodes[i] = &list.Content[i]
+	}
+
+	var result []Sequence
+	for _, node := range a.list(list, "Content", nodes) {
+		switch item := node.(type) {
+		case *Sequence:
+			result = append(result, *item)
+		case Sequence:
+			result = append(result, item)
+		default:
+			panic(fmt.Sprintf("mark.Apply: cannot use %T as list item", node))
+		}
+	}
+	return result
+}
+
+func (a *application) notes(sec *Section) []Note {
+	nodes := make([]Node, len(sec.Notes))
+	for i := range sec.Notes {
+		nodes[i] = &sec.Notes[i]
+	}
+
+	var result []Note
+	for _, node := range a.list(sec, "Notes", nodes) {
+		switch note := node.(type) {
+		case *Note:
+			result = append(result, *note)
+		case Note:
+			result = append(result, note)
+		default:
+			panic(fmt.Sprintf("mark.Apply: cannot use %T as Note", node))
+		}
+	}
+	return result
+}
+
+// children applies to the children of node and returns the updated node,
+// which differs from node for inlines.
+func (a *application) children(node Node) Node {
+	switch node := node.(type) {
+	case Sequence:
+		return a.blocks(node, "Content", node)
+	case *Sequence:
+		*node = a.blocks(node, "Content", *node)
+	case *Section:
+		a.paragraph(node, "Title", &node.Title)
+		node.Content = a.blocks(node, "Content", node.Content)
+		node.Notes = a.notes(node)
+	case *Note:
+		node.Content = a.blocks(node, "Content", node.Content)
+	case *Quote:
+		if !node.Title.IsEmpty() {
+			a.paragraph(node, "Title", &node.Title)
+		}
+		node.Content = a.blocks(node, "Content", node.Content)
+	case *Modifier:
+		node.Content = a.blocks(node, "Content", node.Content)
+	case *List:
+		node.Content = a.items(node)
+	case *Separator:
+		if !node.Title.IsEmpty() {
+			a.paragraph(node, "Title", &node.Title)
+		}
+	case *Paragraph:
+		node.Items = a.inlines(node, "Items", node.Items)
+	case Emphasis:
+		node.Items = a.inlines(node, "Items", node.Items)
+		return node
+	case Bold:
+		node.Items = a.inlines(node, "Items", node.Items)
+		return node
+	case Link:
+		a.paragraph(node, "Title", &node.Title)
+		return node
+	case Image:
+		a.paragraph(node, "Alt", &node.Alt)
+		return node
+	case InlineModifier:
+		if node.Inline != nil {
+			result := a.field(node, "Inline", node.Inline)
+			inline, ok := result.(Inline)
+			if !ok {
+				panic(fmt.Sprintf("mark.Apply: cannot use %T as Inline", result))
+			}
+			node.Inline = inline
+		}
+		return node
+	}
+	return node
+}
@@ -0,0 +1,128 @@
+package mark_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/loov/mark"
+	"github.com/loov/mark/html"
+)
+
+func TestApply(t *testing.T) {
+	content := "# Title\n\nA *b* c\n\nD\n\n> E"
+	seq, _ := mark.ParseContent(mark.VirtualDir{}, "main.md", []byte(content))
+
+	result := mark.Apply(seq, func(c *mark.Cursor) bool {
+		switch node := c.Node().(type) {
+		case mark.Text:
+			switch node.Value {
+			case "b":
+				node.Value = "B"
+				c.Replace(node)
+			case "D":
+				c.Delete()
+			}
+		case *mark.Quote:
+			c.InsertBefore(&mark.Separator{})
+			c.InsertAfter(Para(Text("after")))
+			return false
+		}
+		return true
+	}, func(c *mark.Cursor) bool {
+		if p, ok := c.Node().(*mark.Paragraph); ok && c.Name() == "Content" && len(p.Items) == 0 {
+			c.Delete()
+		}
+		return true
+	})
+
+	got := html.Convert(result.(mark.Sequence))
+	exp := "<section><h1>Title</h1><p>A <em>B</em> c</p><hr><blockquote><p>E</p></blockquote><p>after</p></section>"
+	if got != exp {
+		t.Errorf("got\n%s\nexp\n%s", got, exp)
+	}
+}
+
+func TestApplyCursor(t *testing.T) {
+	seq, _ := mark.ParseContent(mark.VirtualDir{}, "main.md", []byte("# Title\n\nA **b**\n\nC"))
+
+	var got []string
+	mark.Apply(seq, func(c *mark.Cursor) bool {
+		if c.Parent() != nil {
+			got = append(got, fmt.Sprintf("%s.%s[%d] %s", nodeName(c.Parent()), c.Name(), c.Index(), nodeName(c.Node())))
+		}
+		return true
+	}, func(c *mark.Cursor) bool {
+		_, ok := c.Node().(mark.Bold)
+		return !ok
+	})
+
+	exp := []string{
+		"Sequence.Content[0] Section",
+		"Section.Title[-1] Paragraph",
+		"Paragraph.Items[0] Text",
+		"Section.Content[0] Paragraph",
+		"Paragraph.Items[0] Text",
+		"Paragraph.Items[1] Bold",
+		"Bold.Items[0] Text",
+	}
+	if strings.Join(got, "\n") != strings.Join(exp, "\n") {
+		t.Errorf("got\n%s\nexp\n%s", strings.Join(got, "\n"), strings.Join(exp, "\n"))
+	}
+}
+
+func TestApplyPanics(t *testing.T) {
+	seq, _ := mark.ParseContent(mark.VirtualDir{}, "main.md", []byte("# Title"))
+
+	tests := map[string]func(c *mark.Cursor){
+		"delete field": func(c *mark.Cursor) {
+			if c.Name() == "Title" {
+				c.Delete()
+			}
+		},
+		"inline in blocks": func(c *mark.Cursor) {
+			if _, ok := c.Node().(*mark.Section); ok {
+				c.Replace(mark.Text{Value: "x"})
+			}
+		},
+	}
+	for name, fn := range tests {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%s: expected panic", name)
+				}
+			}()
+			mark.Apply(seq, func(c *mark.Cursor) bool {
+				fn(c)
+				return true
+			}, nil)
+		}()
+	}
+}
+
+func TestPipeline(t *testing.T) {
+	fs := mark.VirtualDir{
+		"book.md":  "# Book\n\nSee [intro](intro.md).\n\n{.draft}\nUnfinished\n\n## Part",
+		"intro.md": "",
+	}
+	pipeline := &mark.Pipeline{
+		Passes: []mark.Pass{
+			mark.ShiftHeadings(1),
+			mark.RewriteLinks(func(href string) string {
+				return strings.TrimSuffix(href, ".md") + ".html"
+			}),
+			mark.StripModifier("draft"),
+		},
+	}
+	seq, errs := pipeline.ParseFile(fs, "book.md")
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+
+	got := html.Convert(seq)
+	exp := `<section><h2>Book</h2><p>See <a href="intro.html">intro</a>.</p><section><h3>Part</h3></section></section>`
+	if got != exp {
+		t.Errorf("got\n%s\nexp\n%s", got, exp)
+	}
+}
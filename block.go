@@ -1,8 +1,14 @@
 package mark
 
+import (
+	"strings"
+	"unicode"
+)
+
 // Main organizational Blocbs
 type Block interface {
 	TagBlock()
+	Pos() Position
 }
 
 func (Sequence) TagBlock()  {}
@@ -18,23 +24,93 @@ func (s *Sequence) Append(block Block) {
 	*s = append(*s, block)
 }
 
+// Pos returns the range from the first to the last block
+func (s Sequence) Pos() Position {
+	if len(s) == 0 {
+		return Position{}
+	}
+	pos := s[0].Pos()
+	if last := s[len(s)-1].Pos(); last.Path == pos.Path {
+		pos.End = last.End
+	}
+	return pos
+}
+
 // Paragraph represents a `<p>`
 type Paragraph struct {
+	Position
 	Items []Inline
 }
 
 func (p *Paragraph) IsEmpty() bool { return len(p.Items) == 0 }
 
+// PlainText returns the text content without any formatting
+func (p *Paragraph) PlainText() string { return plainText(p.Items) }
+
+func plainText(items []Inline) (r string) {
+	for _, item := range items {
+		switch item := item.(type) {
+		case Text:
+			r += item.Value
+		case CodeSpan:
+			r += item.Value
+		case Emphasis:
+			r += plainText(item.Items)
+		case Bold:
+			r += plainText(item.Items)
+		case SoftBreak, HardBreak:
+			r += " "
+		case Link:
+			r += item.Title.PlainText()
+		case Image:
+			r += item.Alt.PlainText()
+		case InlineModifier:
+			r += plainText([]Inline{item.Inline})
+		}
+	}
+	return r
+}
+
+// Slug converts text into a lowercase identifier separated by dashes
+func Slug(text string) string {
+	var slug []rune
+	dash := false
+	for _, r := range strings.ToLower(text) {
+		if unicode.IsLetter(r) || unicode.IsNumber(r) {
+			if dash && len(slug) > 0 {
+				slug = append(slug, '-')
+			}
+			slug = append(slug, r)
+			dash = false
+		} else {
+			dash = true
+		}
+	}
+	return string(slug)
+}
+
 // Section contains information about a titled Sequence `<section>`
 type Section struct {
+	Position
 	Level   int
+	ID      string // explicit id from `# Title {#id}`
 	Title   Paragraph
 	Content Sequence
 	Notes   []Note
 }
 
+// Slug returns the explicit ID or an ID derived from the title,
+// e.g. "Getting Started" becomes "getting-started".
+func (sec *Section) Slug() string {
+	if sec.ID != "" {
+		return sec.ID
+	}
+	return Slug(sec.Title.PlainText())
+}
+
 // Quote represents a nested block, such as quotes or figures `<blockquote>`
 type Quote struct {
+	Position
 	Category string
 	Title    Paragraph
 	Content  Sequence
@@ -42,6 +118,7 @@ type Quote struct {
 
 // Modifier represents a container with a specific classname `<div>`
 type Modifier struct {
+	Position
 	Class   string
 	Content Sequence
 }
@@ -52,17 +129,47 @@ func (Separator) TagBlock() {}
 
 // Code is a block of code `<pre>`
 type Code struct {
+	Position
 	Language string
-	Lines    []string
+	// Info is the full info string of a fenced code block,
+	// e.g. `go title="main.go" {3-5} linenos`
+	Info        string
+	Title       string            // title="main.go"
+	Highlight   []LineRange       // {3-5}, emphasised lines using displayed numbers
+	LineNumbers bool              // linenos
+	LineOffset  int               // linenos=10 gives 9, added to 1-based line numbers
+	Attrs       map[string]string // other attributes from the info string
+
+	Lines []string
+}
+
+// LineNumber returns the displayed number of line at index i
+func (code *Code) LineNumber(i int) int {
+	return code.LineOffset + i + 1
+}
+
+// LineRange is an inclusive range of 1-based line numbers
+type LineRange struct{ From, To int }
+
+// Highlighted checks whether line with displayed number n is emphasised
+func (code *Code) Highlighted(n int) bool {
+	for _, r := range code.Highlight {
+		if r.From <= n && n <= r.To {
+			return true
+		}
+	}
+	return false
 }
 
 // List is a list of different Sequence Blocks `<ul>`, `<ol>`
 type List struct {
+	Position
 	Ordered bool
 	Content []Sequence
 }
 
 // Separator is a horizontal-rule with an optional title `<hr>`
 type Separator struct {
+	Position
 	Title Paragraph //TODO: remove, use a section instead for titles
 }
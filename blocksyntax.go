@@ -0,0 +1,95 @@
+package mark
+
+import "strings"
+
+// Priorities of built-in block syntaxes, lower priority is tried first.
+// Syntaxes with equal priority are tried in the order they were added,
+// after the built-in ones.
+const (
+	PriorityConditional  = 100
+	PriorityIgnore       = 150
+	PriorityQuote        = 200
+	PrioritySeparator    = 300
+	PriorityList         = 400
+	PriorityNumberedList = 500
+	PriorityHeading      = 600
+	PrioritySetext       = 700
+	PriorityIndentedCode = 800
+	PriorityFence        = 900
+	PriorityDirective    = 1000
+	PriorityModifier     = 1100
+)
+
+// BlockSyntax describes custom block syntax.
+//
+// Start is called with a line that has container prefixes (such as `>`)
+// removed. When it returns true, Parse is called with the reader positioned
+// at that line. Parse consumes the lines of the block using BlockContext.
+type BlockSyntax struct {
+	Priority int
+	Start    func(line string) bool
+	Parse    func(ctx *BlockContext) ([]Block, []error)
+}
+
+// BlockContext reads lines of a custom block
+type BlockContext struct {
+	FS   FileSystem
+	Path string // current file path relative to FS root
+
+	parse  *parse
+	unread bool
+}
+
+// Line returns the current line without container prefixes
+func (ctx *BlockContext) Line() string { return string(ctx.parse.reader.line()) }
+
+// LineNumber returns the current line number
+func (ctx *BlockContext) LineNumber() int { return ctx.parse.reader.head.line }
+
+// Next moves to the next line of the block. It returns false at the end of
+// input or when the line doesn't belong to the enclosing container.
+func (ctx *BlockContext) Next() bool {
+	ctx.unread = false
+	return ctx.parse.reader.nextLine()
+}
+
+// Unread returns the current line to the enclosing parser, closing the block.
+// It can only be called once after Next.
+func (ctx *BlockContext) Unread() {
+	if ctx.unread {
+		return
+	}
+	ctx.unread = true
+	ctx.parse.reader.undoNextLine()
+}
+
+// Nested parses the current and following lines that start with symbol
+// as nested markdown, similarly to `>` in quotes.
+func (ctx *BlockContext) Nested(symbol rune) (Sequence, []error) {
+	return ctx.parse.nested(symbol)
+}
+
+// ParseContent parses content, such as the body of the block,
+// as nested markdown of the current file.
+func (ctx *BlockContext) ParseContent(content string) (Sequence, []error) {
+	return ctx.parse.parseContent(content)
+}
+
+// ParseLines parses lines as nested markdown
+func (ctx *BlockContext) ParseLines(lines []string) (Sequence, []error) {
+	return ctx.ParseContent(strings.Join(lines, "\n"))
+}
+
+// customBlock parses a block with a custom syntax
+func (parse *parse) customBlock(syntax BlockSyntax) {
+	parse.flushParagraph()
+
+	ctx := &BlockContext{
+		FS:    parse.fs,
+		Path:  parse.path,
+		parse: parse,
+	}
+	blocks, errs := syntax.Parse(ctx)
+	parse.report(errs)
+	parse.mergeBlocks(blocks)
+}
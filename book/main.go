@@ -1,26 +1,128 @@
 package main
 
 import (
+	"encoding/json"
+	"flag"
 	"fmt"
 	"io/ioutil"
+	"os"
 	"strings"
 
 	"github.com/kr/pretty"
 
 	"github.com/loov/mark"
+	"github.com/loov/mark/fix"
 	"github.com/loov/mark/html"
+	"github.com/loov/mark/lint"
+	"github.com/loov/mark/report"
 )
 
+var (
+	configfile = flag.String("config", "", "book config file")
+	listvars   = flag.Bool("list-vars", false, "list referenced variables")
+	tags       = flag.String("tags", "", "comma separated build tags for `{if tag}` blocks")
+	format     = flag.String("format", "text", "diagnostics format: text, json or sarif")
+	verbose    = flag.Bool("verbose", false, "include suppressed diagnostics and print a summary")
+	lintbook   = flag.Bool("lint", false, "check style rules, see package lint")
+	fixfiles   = flag.Bool("fix", false, "rewrite files to fix problems where possible")
+	difffiles  = flag.Bool("diff", false, "print fixes as a diff without rewriting files")
+	vars       = varsFlag{}
+)
+
+func init() {
+	flag.Var(vars, "var", "variable `name=value`, can be repeated")
+}
+
+// varsFlag collects `-var name=value` flags
+type varsFlag map[string]string
+
+func (vars varsFlag) String() string { return fmt.Sprint(map[string]string(vars)) }
+func (vars varsFlag) Set(s string) error {
+	eq := strings.Index(s, "=")
+	if eq <= 0 {
+		return fmt.Errorf("expected name=value, got %q", s)
+	}
+	vars[s[:eq]] = s[eq+1:]
+	return nil
+}
+
+// Config is the book configuration
+type Config struct {
+	Vars map[string]string `json:"vars"`
+}
+
 func main() {
+	flag.Parse()
+
+	parser := &mark.Parser{Vars: map[string]string{}}
+	if *configfile != "" {
+		data, err := ioutil.ReadFile(*configfile)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		var config Config
+		if err := json.Unmarshal(data, &config); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", *configfile, err)
+			os.Exit(1)
+		}
+		for name, value := range config.Vars {
+			parser.Vars[name] = value
+		}
+	}
+	for name, value := range vars {
+		parser.Vars[name] = value
+	}
+	if *tags != "" {
+		parser.Tags = strings.Split(*tags, ",")
+	}
+	parser.KeepSuppressed = *verbose
+
+	switch *format {
+	case "text", "json", "sarif":
+	default:
+		fmt.Fprintf(os.Stderr, "unknown format %q\n", *format)
+		os.Exit(2)
+	}
+
+	if *listvars {
+		names, errs := parser.ReferencedVars(mark.Dir("."), "example.md")
+		for _, err := range errs {
+			fmt.Fprintln(os.Stderr, err)
+		}
+		for _, name := range names {
+			fmt.Println(name)
+		}
+		return
+	}
+
+	if *fixfiles || *difffiles {
+		_, errs := parseBook(parser)
+		applyFixes(errs)
+		return
+	}
+
+	if *format != "text" {
+		_, errs := parseBook(parser)
+		writeDiagnostics(errs)
+		if report.HasErrors(errs) {
+			os.Exit(1)
+		}
+		return
+	}
+
 	// print a clearing block
 	fmt.Println("CLEAR")
 	fmt.Println(strings.Repeat("\n", 32))
 	fmt.Println(strings.Repeat("-", 32))
 
 	pretty.Printf("Parsing example.md\n\n")
-	sequence, errs := mark.ParseFile(mark.Dir("."), "example.md")
+	sequence, errs := parseBook(parser)
 	for _, err := range errs {
-		pretty.Printf("%v\n", err)
+		fmt.Println(mark.Pretty(err))
+	}
+	if *verbose {
+		fmt.Println(report.Count(errs))
 	}
 	pretty.Printf("\n%# v\n\n", sequence)
 
@@ -32,6 +134,18 @@ func main() {
 			code { background: #000; color: #fff; }
 			pre > code { background: inherit; color: inherit; }
 			pre  { background: #000; color: #fff; padding: 0.5em; }
+			pre .line { display: inline-block; width: 100%; }
+			pre .line::before { content: attr(data-line); display: inline-block; width: 2em; color: #888; }
+			pre .hl { background: #333; }
+			pre .kw { color: #f92672; }
+			pre .bi, pre .num { color: #ae81ff; }
+			pre .str { color: #e6db74; }
+			pre .com { color: #75715e; }
+			pre .var, pre .attr { color: #a6e22e; }
+			pre .tag { color: #f92672; }
+			pre .ins { color: #a6e22e; }
+			pre .del { color: #f92672; }
+			pre .meta { color: #66d9ef; }
 			.separator { text-align: center; background: #eee; }
 
 			.warning { background: #fee; }
@@ -44,4 +158,53 @@ func main() {
 	`+result), 0755)
 
 	fmt.Println(result)
+
+	if report.HasErrors(errs) {
+		os.Exit(1)
+	}
+}
+
+// parseBook parses example.md and lints it with -lint
+func parseBook(parser *mark.Parser) (mark.Sequence, []error) {
+	sequence, errs := parser.ParseFile(mark.Dir("."), "example.md")
+	if *lintbook {
+		errs = append(errs, lint.Lint(mark.Dir("."), sequence)...)
+	}
+	return sequence, errs
+}
+
+// applyFixes rewrites files with fixes for errs, with -diff
+// the fixes are printed instead.
+func applyFixes(errs []error) {
+	files, err := fix.Collect(mark.Dir("."), errs)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	for _, file := range files {
+		if *difffiles {
+			fmt.Print(file.Diff())
+			continue
+		}
+		if err := ioutil.WriteFile(file.Path, file.Apply(), 0644); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		fmt.Fprintf(os.Stderr, "%s: fixed %d problems\n", file.Path, len(file.Fixed))
+	}
+}
+
+// writeDiagnostics writes errs to stdout in the -format
+func writeDiagnostics(errs []error) {
+	var err error
+	switch *format {
+	case "json":
+		err = report.JSONLines(os.Stdout, errs)
+	case "sarif":
+		err = report.SARIF(os.Stdout, errs)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
 }
@@ -0,0 +1,238 @@
+package mark
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// Conditional blocks include content only when the condition matches
+// Parser.Tags:
+//
+//	{if print && !draft}
+//	Content for print.
+//	{else}
+//	Content for other editions.
+//	{end}
+//
+// Conditions may use `&&`, `||`, `!` and parentheses.
+
+// condBlock is an open `{if}` block
+type condBlock struct {
+	directive string // `{if ...}` line
+	inElse    bool   // after the `{else}` line
+}
+
+func (parse *parse) conditional() {
+	parse.flushParagraph()
+	directive := strings.TrimSpace(string(parse.reader.line()))
+
+	switch directive {
+	case "{else}":
+		if len(parse.conditions) == 0 {
+			parse.check(ErrBadCondition.Errorf("Unexpected {else} without {if}"))
+			return
+		}
+		open := parse.conditions[len(parse.conditions)-1]
+		parse.conditions = parse.conditions[:len(parse.conditions)-1]
+		if open.inElse {
+			parse.check(ErrBadCondition.Errorf("Duplicate {else} for %s", open.directive))
+		}
+		for parse.skipConditional() == "{else}" {
+			parse.check(ErrBadCondition.Errorf("Duplicate {else} for %s", open.directive))
+		}
+	case "{end}":
+		if len(parse.conditions) == 0 {
+			parse.check(ErrBadCondition.Errorf("Unexpected {end} without {if}"))
+			return
+		}
+		parse.conditions = parse.conditions[:len(parse.conditions)-1]
+	default:
+		expr := strings.TrimSpace(directive[len("{if ") : len(directive)-1])
+		active, err := evalCondition(expr, parse.hasTag)
+		if err != nil {
+			parse.check(ErrBadCondition.Errorf("Invalid condition %q: %v", expr, err))
+		}
+		if active {
+			parse.conditions = append(parse.conditions, condBlock{directive: directive})
+			return
+		}
+		if parse.skipConditional() == "{else}" {
+			parse.conditions = append(parse.conditions, condBlock{directive: directive, inElse: true})
+		}
+	}
+}
+
+// skipConditional skips lines until matching `{else}` or `{end}` and
+// returns the one that was found. Lines in fenced code are skipped
+// without looking for directives.
+func (parse *parse) skipConditional() string {
+	reader := parse.reader
+	depth := 0
+	var fencechar rune
+	fencesize := 0
+	for reader.nextLine() {
+		line := reader.line()
+		if fencesize > 0 {
+			if line.IsClosingFence(fencechar, fencesize) {
+				fencesize = 0
+			}
+			continue
+		}
+		if line.StartsFence() {
+			trimmed := line.trim3()
+			fencechar = rune(trimmed[0])
+			fencesize = len(trimmed) - len(strings.TrimLeft(trimmed, trimmed[:1]))
+			continue
+		}
+		if !line.StartsConditional() {
+			continue
+		}
+		switch directive := strings.TrimSpace(string(line)); directive {
+		case "{else}":
+			if depth == 0 {
+				return directive
+			}
+		case "{end}":
+			if depth == 0 {
+				return directive
+			}
+			depth--
+		default:
+			depth++
+		}
+	}
+	parse.check(ErrBadCondition.Errorf("Did not find {end}"))
+	return ""
+}
+
+// closeConditions reports `{if}` blocks that were not closed
+func (parse *parse) closeConditions() {
+	for _, open := range parse.conditions {
+		parse.check(ErrBadCondition.Errorf("Did not find {end} for %s", open.directive))
+	}
+	parse.conditions = nil
+}
+
+func (parse *parse) hasTag(tag string) bool {
+	for _, active := range parse.book.Tags {
+		if active == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// evalCondition evaluates a boolean expression of tags
+func evalCondition(expr string, hasTag func(string) bool) (bool, error) {
+	cond := &condition{hasTag: hasTag}
+	if err := cond.tokenize(expr); err != nil {
+		return false, err
+	}
+	if len(cond.tokens) == 0 {
+		return false, errors.New("empty condition")
+	}
+	result, err := cond.or()
+	if err != nil {
+		return false, err
+	}
+	if len(cond.tokens) > 0 {
+		return false, fmt.Errorf("unexpected %q", cond.tokens[0])
+	}
+	return result, nil
+}
+
+type condition struct {
+	tokens []string
+	hasTag func(string) bool
+}
+
+func (cond *condition) tokenize(expr string) error {
+	for i := 0; i < len(expr); {
+		switch c := expr[i]; {
+		case c == ' ' || c == '\t':
+			i++
+		case strings.HasPrefix(expr[i:], "&&"), strings.HasPrefix(expr[i:], "||"):
+			cond.tokens = append(cond.tokens, expr[i:i+2])
+			i += 2
+		case c == '!' || c == '(' || c == ')':
+			cond.tokens = append(cond.tokens, expr[i:i+1])
+			i++
+		default:
+			start := i
+			for i < len(expr) && isTagRune(rune(expr[i])) {
+				i++
+			}
+			if start == i {
+				return fmt.Errorf("unexpected %q", expr[i:i+1])
+			}
+			cond.tokens = append(cond.tokens, expr[start:i])
+		}
+	}
+	return nil
+}
+
+func isTagRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' || r == '-' || r == '.'
+}
+
+func (cond *condition) peek() string {
+	if len(cond.tokens) == 0 {
+		return ""
+	}
+	return cond.tokens[0]
+}
+
+func (cond *condition) next() string {
+	t := cond.peek()
+	if len(cond.tokens) > 0 {
+		cond.tokens = cond.tokens[1:]
+	}
+	return t
+}
+
+func (cond *condition) or() (bool, error) {
+	result, err := cond.and()
+	for err == nil && cond.peek() == "||" {
+		cond.next()
+		var rhs bool
+		rhs, err = cond.and()
+		result = result || rhs
+	}
+	return result, err
+}
+
+func (cond *condition) and() (bool, error) {
+	result, err := cond.unary()
+	for err == nil && cond.peek() == "&&" {
+		cond.next()
+		var rhs bool
+		rhs, err = cond.unary()
+		result = result && rhs
+	}
+	return result, err
+}
+
+func (cond *condition) unary() (bool, error) {
+	switch t := cond.next(); t {
+	case "":
+		return false, errors.New("unexpected end of condition")
+	case "!":
+		result, err := cond.unary()
+		return !result, err
+	case "(":
+		result, err := cond.or()
+		if err != nil {
+			return false, err
+		}
+		if cond.next() != ")" {
+			return false, errors.New("missing )")
+		}
+		return result, nil
+	case ")", "&&", "||":
+		return false, fmt.Errorf("unexpected %q", t)
+	default:
+		return cond.hasTag(t), nil
+	}
+}
@@ -0,0 +1,224 @@
+package mark
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// Severity describes how serious a diagnostic is
+type Severity int
+
+const (
+	SeverityError Severity = iota
+	SeverityWarning
+	SeverityInfo
+)
+
+func (severity Severity) String() string {
+	switch severity {
+	case SeverityError:
+		return "error"
+	case SeverityWarning:
+		return "warning"
+	case SeverityInfo:
+		return "info"
+	}
+	return fmt.Sprintf("Severity(%d)", int(severity))
+}
+
+// ErrorCode is a stable machine-readable identifier of a check.
+//
+// Codes are sentinel errors, a diagnostic can be tested with
+//
+//	errors.Is(err, mark.ErrMissingFile)
+type ErrorCode string
+
+// Codes of the built-in checks
+const (
+	ErrMissingFile       ErrorCode = "missing-file"       // linked or included file doesn't exist
+	ErrRecursiveInclude  ErrorCode = "recursive-include"  // file includes itself
+	ErrBadInclude        ErrorCode = "bad-include"        // invalid include arguments or targets
+	ErrUnclosedFence     ErrorCode = "unclosed-fence"     // code fence without an ending fence
+	ErrBadHeading        ErrorCode = "bad-heading"        // malformed `#` heading
+	ErrUndefinedVariable ErrorCode = "undefined-variable" // `{{$name}}` without a value
+	ErrRecursiveVariable ErrorCode = "recursive-variable" // variable that expands itself
+	ErrBadCondition      ErrorCode = "bad-condition"      // malformed `{if}` blocks
+	ErrUnsupported       ErrorCode = "unsupported"        // syntax that is not implemented
+	ErrSyntax            ErrorCode = "syntax"             // other malformed syntax
+	ErrInternal          ErrorCode = "internal"           // recovered panic
+	ErrCustom            ErrorCode = "custom"             // errors from directives and custom syntax without a code
+)
+
+func (code ErrorCode) Error() string { return string(code) }
+
+// Severity returns the default severity of diagnostics with code
+func (code ErrorCode) Severity() Severity {
+	switch code {
+	case ErrUnclosedFence, ErrBadHeading, ErrUnsupported:
+		return SeverityWarning
+	}
+	return SeverityError
+}
+
+// Errorf formats an error with code, directives can use it
+// to report errors with a specific code.
+func (code ErrorCode) Errorf(format string, args ...interface{}) error {
+	return &codedError{code, fmt.Errorf(format, args...)}
+}
+
+type codedError struct {
+	code ErrorCode
+	err  error
+}
+
+func (err *codedError) Error() string        { return err.err.Error() }
+func (err *codedError) Unwrap() error        { return err.err }
+func (err *codedError) Is(target error) bool { return target == error(err.code) }
+
+// codeOf finds the code of err
+func codeOf(err error) ErrorCode {
+	var coded *codedError
+	if errors.As(err, &coded) {
+		return coded.code
+	}
+	var code ErrorCode
+	if errors.As(err, &code) {
+		return code
+	}
+	return ErrCustom
+}
+
+// diagnose reports err at pos with the default severity of its code
+func (parse *parse) diagnose(pos Position, err error) *ParseError {
+	code := codeOf(err)
+	diag := &ParseError{
+		Path:     pos.Path,
+		Line:     pos.Start.Line,
+		Err:      err,
+		Column:   pos.Start.Column,
+		Severity: code.Severity(),
+		Code:     code,
+		Source:   parse.sourceLine(pos),
+		Stack:    parse.includeStack(),
+	}
+	if pos.End.Line == pos.Start.Line {
+		diag.EndColumn = pos.End.Column
+	}
+	parse.errors = append(parse.errors, diag)
+	return diag
+}
+
+// Edit replaces bytes between offsets Start and End of a file with Text
+type Edit struct {
+	Path       string
+	Start, End int
+	Text       string
+}
+
+// edit returns an edit of the current file, nil for virtual content
+// where offsets don't refer to a file.
+func (parse *parse) edit(from, to int, text string) []Edit {
+	if parse.virtual {
+		return nil
+	}
+	return []Edit{{Path: parse.path, Start: from, End: to, Text: text}}
+}
+
+// Frame is a location in the include chain
+type Frame struct {
+	Path string
+	Line int
+}
+
+func (frame Frame) String() string { return fmt.Sprintf("%s:%d", frame.Path, frame.Line) }
+
+// includeStack returns the locations of includes leading to the current file
+func (parse *parse) includeStack() (stack []Frame) {
+	for p := parse; p.parent != nil; p = p.parent {
+		if p.path == p.parent.path {
+			// nested content of the same file
+			continue
+		}
+		pos := p.parent.linePosition()
+		stack = append([]Frame{{pos.Path, pos.Start.Line}}, stack...)
+	}
+	return stack
+}
+
+// sourceLine returns the text of the first line of pos
+func (parse *parse) sourceLine(pos Position) string {
+	for parse.virtual && parse.parent != nil {
+		parse = parse.parent
+	}
+	rd := parse.reader
+	if !pos.IsValid() || pos.Start.Offset > len(rd.content) {
+		return ""
+	}
+	start := pos.Start.Offset - (pos.Start.Column - 1)
+	if start < 0 {
+		return ""
+	}
+	line := rd.content[start:]
+	if end := strings.IndexAny(line, "\r\n"); end >= 0 {
+		line = line[:end]
+	}
+	return line
+}
+
+// Pretty formats err with the offending source line underlined,
+// similarly to the Go compiler:
+//
+//	ch1.md:3:5: warning: Cannot find file x.md [missing-file]
+//		included from book.md:2 → ch1.md:3
+//		See [x](x.md) for details.
+//		    ^^^^^^^^^
+//
+// Errors other than *ParseError are formatted with Error.
+func Pretty(err error) string {
+	var diag *ParseError
+	if !errors.As(err, &diag) {
+		return err.Error()
+	}
+
+	var r strings.Builder
+	r.WriteString(diag.Path)
+	fmt.Fprintf(&r, ":%d", diag.Line)
+	if diag.Column > 0 {
+		fmt.Fprintf(&r, ":%d", diag.Column)
+	}
+	fmt.Fprintf(&r, ": %v: %v", diag.Severity, diag.Err)
+	if diag.Code != "" {
+		fmt.Fprintf(&r, " [%s]", diag.Code)
+	}
+	if diag.Suppressed {
+		r.WriteString(" (suppressed)")
+	}
+	if len(diag.Stack) > 0 {
+		r.WriteString("\n\tincluded from " + diag.Trace())
+	}
+
+	if diag.Source == "" || diag.Column <= 0 || diag.Column > len(diag.Source)+1 {
+		return r.String()
+	}
+
+	end := diag.EndColumn
+	if end <= diag.Column || end > len(diag.Source)+1 {
+		end = len(diag.Source) + 1
+	}
+	r.WriteString("\n\t" + diag.Source + "\n\t")
+	for _, c := range diag.Source[:diag.Column-1] {
+		if c == '\t' {
+			r.WriteByte('\t')
+		} else {
+			r.WriteByte(' ')
+		}
+	}
+	carets := utf8.RuneCountInString(diag.Source[diag.Column-1 : end-1])
+	if carets < 1 {
+		carets = 1
+	}
+	r.WriteString(strings.Repeat("^", carets))
+	return r.String()
+}
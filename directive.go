@@ -0,0 +1,215 @@
+package mark
+
+import (
+	"strings"
+)
+
+// Directive handles `{{name args}}` and `{name args}` lines. A `{name}`
+// line without arguments is a class modifier instead.
+//
+// The returned blocks are placed into the current sequence, sections are
+// nested according to their levels. Errors that are not a *ParseError are
+// reported at the directive line.
+type Directive func(ctx *DirectiveContext, args string) ([]Block, []error)
+
+// Directives maps directive names to their implementations
+type Directives map[string]Directive
+
+// DefaultDirectives are available in every parse, Parser.Directives
+// take precedence over them.
+//
+// A line `{{path}}` whose first word is not a directive name is handled
+// by the "include" directive.
+var DefaultDirectives Directives
+
+func init() {
+	DefaultDirectives = Directives{
+		"include": includeDirective,
+		"code":    codeDirective,
+	}
+}
+
+// DirectiveContext describes where the directive is invoked
+type DirectiveContext struct {
+	FS   FileSystem
+	Path string // current file path relative to FS root
+	Line int
+	Name string // directive name
+
+	parse *parse
+}
+
+// Abs returns the path relative to the FileSystem root
+// for a reference relative to the current file.
+func (ctx *DirectiveContext) Abs(ref string) string { return ctx.parse.reltoabs(ref) }
+
+// Level returns the level of the innermost section, 0 when outside of any section
+func (ctx *DirectiveContext) Level() int { return ctx.parse.currentLevel() }
+
+// ParseFile parses a file relative to FS root as nested content of the
+// current file. Recursive includes are reported as an error.
+func (ctx *DirectiveContext) ParseFile(abs string) (Sequence, []error) {
+	parent := ctx.parse
+	if parent.hasPath(abs) {
+		return nil, []error{ErrRecursiveInclude.Errorf("Cannot recursively include %v", abs)}
+	}
+	if parent.fs == nil {
+		return nil, []error{ErrMissingFile.Errorf("Cannot find file %v", abs)}
+	}
+
+	content, err := parent.fs.ReadFile(abs)
+	if err != nil {
+		return nil, []error{ErrMissingFile.Errorf("Failed to read file %v: %v", abs, err)}
+	}
+
+	child := parent.child(abs, string(content))
+	child.frontMatter()
+	child.run()
+	return child.sequence, child.errors
+}
+
+// ParseContent parses content as nested markdown,
+// references in content are relative to the current file.
+func (ctx *DirectiveContext) ParseContent(content string) (Sequence, []error) {
+	return ctx.parse.parseContent(content)
+}
+
+// child creates a parser for nested content
+func (parent *parse) child(path string, content string) *parse {
+	child := &parse{
+		fs:     parent.fs,
+		path:   path,
+		state:  &state{},
+		reader: &reader{},
+		book:   parent.book,
+
+		parent: parent,
+	}
+	child.reader.content = content
+	return child
+}
+
+// parseContent parses content that is not from a file,
+// references are relative to the current file
+func (parent *parse) parseContent(content string) (Sequence, []error) {
+	child := parent.child(parent.path, content)
+	child.virtual = true
+	child.run()
+	return child.sequence, child.errors
+}
+
+// nested parses lines starting with symbol from the current line onwards,
+// such as `>` of quotes, and continues parent after them
+func (parent *parse) nested(symbol rune) (Sequence, []error) {
+	child := parent.child(parent.path, "")
+	*child.reader = *parent.reader
+
+	child.reader.prefixes = append(append([]prefix{}, parent.reader.prefixes...), prefix{
+		symbol: symbol,
+	})
+	child.reader.setNextLineStart(parent.reader.head.start)
+
+	child.run()
+	parent.reader.head = child.reader.head
+	return child.sequence, child.errors
+}
+
+// lookupDirective finds directive by name
+func (parse *parse) lookupDirective(name string) (Directive, bool) {
+	if fn, ok := parse.book.Directives[name]; ok && fn != nil {
+		return fn, true
+	}
+	fn, ok := DefaultDirectives[name]
+	return fn, ok && fn != nil
+}
+
+// directive handles `{{...}}` lines
+func (parse *parse) directive() {
+	reader := parse.reader
+	if line := strings.TrimSpace(string(reader.line())); strings.HasPrefix(line, "{{$") {
+		if name, ok := blockVariable(line); ok {
+			parse.flushParagraph()
+			parse.expandVariable(name)
+		} else {
+			parse.line()
+		}
+		return
+	}
+	parse.flushParagraph()
+
+	reader.ignoreN('{', 2)
+	reader.ignoreTrailingN('}', 2)
+
+	text := strings.TrimSpace(reader.rest())
+	name, args := text, ""
+	if space := strings.IndexAny(text, " \t"); space >= 0 {
+		name, args = text[:space], strings.TrimSpace(text[space:])
+	}
+
+	if fn, ok := parse.lookupDirective(name); ok {
+		parse.invoke(name, fn, args)
+		return
+	}
+	fn, _ := parse.lookupDirective("include")
+	parse.invoke("include", fn, text)
+}
+
+// invoke calls the directive and merges the results
+func (parse *parse) invoke(name string, fn Directive, args string) {
+	ctx := &DirectiveContext{
+		FS:    parse.fs,
+		Path:  parse.path,
+		Line:  parse.reader.head.line,
+		Name:  name,
+		parse: parse,
+	}
+
+	blocks, errs := fn(ctx, args)
+	parse.report(errs)
+	parse.includedBy(blocks, &Invocation{
+		Position: parse.linePosition(),
+		Name:     name,
+		Args:     args,
+		Text:     strings.TrimSpace(parse.reader.content[parse.reader.head.begin:parse.reader.lineStop()]),
+	})
+	parse.mergeBlocks(blocks)
+}
+
+// includedBy marks the blocks from other files as included by the invocation
+func (parse *parse) includedBy(blocks []Block, by *Invocation) {
+	for _, block := range blocks {
+		switch block := block.(type) {
+		case Sequence:
+			parse.includedBy(block, by)
+		case *Sequence:
+			parse.includedBy(*block, by)
+		case interface{ position() *Position }:
+			if pos := block.position(); pos.IsValid() && pos.Path != parse.path {
+				pos.IncludedBy = by
+			}
+		}
+	}
+}
+
+// report adds errors, errors other than *ParseError are reported at the current line
+func (parse *parse) report(errs []error) {
+	for _, err := range errs {
+		if _, ok := err.(*ParseError); ok {
+			parse.errors = append(parse.errors, err)
+		} else {
+			parse.check(err)
+		}
+	}
+}
+
+// mergeBlocks appends blocks following the section levels
+func (parse *parse) mergeBlocks(blocks []Block) {
+	seq := parse.currentSequence(lastlevel)
+	for _, block := range blocks {
+		setPosition(block, parse.linePosition())
+		if sec, ok := block.(*Section); ok {
+			seq = parse.currentSequence(sec.Level)
+		}
+		seq.Append(block)
+	}
+}
@@ -4,12 +4,16 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 )
 
 type FileSystem interface {
 	FileExists(path string) error
 	ReadFile(path string) ([]byte, error)
+	// ReadDir returns sorted names of entries in a directory,
+	// names of subdirectories end with `/`
+	ReadDir(path string) ([]string, error)
 }
 
 type Dir string
@@ -25,6 +29,23 @@ func (dir Dir) ReadFile(file string) ([]byte, error) {
 	return ioutil.ReadFile(full)
 }
 
+func (dir Dir) ReadDir(file string) ([]string, error) {
+	full := filepath.Join(string(dir), filepath.FromSlash(file))
+	infos, err := ioutil.ReadDir(full)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(infos))
+	for _, info := range infos {
+		if info.IsDir() {
+			names = append(names, info.Name()+"/")
+		} else {
+			names = append(names, info.Name())
+		}
+	}
+	return names, nil
+}
+
 type VirtualDir map[string]string
 
 func (dir VirtualDir) FileExists(file string) error {
@@ -44,6 +65,37 @@ func (dir VirtualDir) ReadFile(file string) ([]byte, error) {
 	return []byte(content), nil
 }
 
+func (dir VirtualDir) ReadDir(file string) ([]string, error) {
+	prefix := strings.Trim(file, "/")
+	if prefix == "." {
+		prefix = ""
+	}
+	if prefix != "" {
+		prefix += "/"
+	}
+
+	seen := map[string]bool{}
+	names := []string{}
+	for full := range dir {
+		if !strings.HasPrefix(full, prefix) {
+			continue
+		}
+		name := full[len(prefix):]
+		if slash := strings.Index(name, "/"); slash >= 0 {
+			name = name[:slash+1]
+		}
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return nil, os.ErrNotExist
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
 func getPathScheme(url string) string {
 	for i, c := range url {
 		switch {
@@ -0,0 +1,266 @@
+// Package fix applies the edits attached to diagnostics, see
+// mark.ParseError.Fix, and formats them as unified diffs.
+package fix
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/loov/mark"
+)
+
+// File contains fixes of a single file
+type File struct {
+	Path   string
+	Source []byte
+	Edits  []mark.Edit // sorted and non-overlapping
+
+	Fixed []error // diagnostics fixed by Edits
+}
+
+// Collect reads files with fixable diagnostics from fs and selects their edits.
+// Diagnostics with edits overlapping an already selected edit are skipped,
+// they can be fixed by running Collect again on the fixed files.
+// Suppressed diagnostics are not fixed.
+func Collect(fs mark.FileSystem, errs []error) ([]*File, error) {
+	files := map[string]*File{}
+	var order []*File
+
+next:
+	for _, err := range errs {
+		var diag *mark.ParseError
+		if !errors.As(err, &diag) || diag.Suppressed || len(diag.Fix) == 0 {
+			continue
+		}
+
+		for _, edit := range diag.Fix {
+			file, ok := files[edit.Path]
+			if !ok {
+				source, err := fs.ReadFile(edit.Path)
+				if err != nil {
+					return nil, err
+				}
+				file = &File{Path: edit.Path, Source: source}
+				files[edit.Path] = file
+				order = append(order, file)
+			}
+			if edit.Start < 0 || edit.Start > edit.End || edit.End > len(file.Source) {
+				continue next
+			}
+			for _, other := range file.Edits {
+				if overlaps(edit, other) {
+					continue next
+				}
+			}
+		}
+
+		for _, edit := range diag.Fix {
+			file := files[edit.Path]
+			file.Edits = append(file.Edits, edit)
+			file.Fixed = append(file.Fixed, err)
+		}
+	}
+
+	result := order[:0]
+	for _, file := range order {
+		if len(file.Edits) == 0 {
+			continue
+		}
+		sort.SliceStable(file.Edits, func(i, k int) bool {
+			return file.Edits[i].Start < file.Edits[k].Start
+		})
+		file.Fixed = unique(file.Fixed)
+		result = append(result, file)
+	}
+	return result, nil
+}
+
+// overlaps checks whether edits change the same bytes,
+// insertions at the same offset also overlap.
+func overlaps(a, b mark.Edit) bool {
+	if a.Start == a.End || b.Start == b.End {
+		return a.Start == b.Start || (a.Start < b.End && b.Start < a.End)
+	}
+	return a.Start < b.End && b.Start < a.End
+}
+
+func unique(errs []error) []error {
+	result := errs[:0]
+	for i, err := range errs {
+		if i > 0 && errs[i-1] == err {
+			continue
+		}
+		result = append(result, err)
+	}
+	return result
+}
+
+// Apply returns Source with Edits applied
+func (file *File) Apply() []byte {
+	return apply(file.Source, file.Edits, 0)
+}
+
+// apply applies sorted edits to source starting at offset
+func apply(source []byte, edits []mark.Edit, offset int) []byte {
+	var r []byte
+	at := 0
+	for _, edit := range edits {
+		r = append(r, source[at:edit.Start-offset]...)
+		r = append(r, edit.Text...)
+		at = edit.End - offset
+	}
+	return append(r, source[at:]...)
+}
+
+// Context is the number of unchanged lines around changes in Diff
+const Context = 3
+
+// Diff returns the changes as a unified diff
+func (file *File) Diff() string {
+	lines := lineRanges(file.Source)
+
+	// blocks of changed lines with the edits inside them
+	type block struct {
+		first, last int // line indices
+		edits       []mark.Edit
+	}
+	var blocks []*block
+	for _, edit := range file.Edits {
+		first := lineOf(lines, edit.Start)
+		last := lineOf(lines, edit.End)
+		if edit.End > edit.Start && last > first && edit.End == lines[last][0] {
+			// ends with a line ending
+			last--
+		}
+		if n := len(blocks); n > 0 && first <= blocks[n-1].last {
+			b := blocks[n-1]
+			if last > b.last {
+				b.last = last
+			}
+			b.edits = append(b.edits, edit)
+			continue
+		}
+		blocks = append(blocks, &block{first, last, []mark.Edit{edit}})
+	}
+
+	var r strings.Builder
+	fmt.Fprintf(&r, "--- a/%s\n+++ b/%s\n", file.Path, file.Path)
+
+	delta := 0 // difference in line counts before the hunk
+	for len(blocks) > 0 {
+		// blocks with overlapping context form a hunk
+		n := 1
+		for n < len(blocks) && blocks[n].first-blocks[n-1].last-1 <= 2*Context {
+			n++
+		}
+		hunk := blocks[:n]
+		blocks = blocks[n:]
+
+		first := hunk[0].first - Context
+		if first < 0 {
+			first = 0
+		}
+		last := hunk[n-1].last + Context
+		if last >= len(lines) {
+			last = len(lines) - 1
+		}
+
+		var body strings.Builder
+		oldCount, newCount := 0, 0
+		unchanged := func(i int) {
+			if line := file.line(lines, i); line != "" {
+				writeLine(&body, ' ', line)
+				oldCount++
+				newCount++
+			}
+		}
+
+		at := first
+		for _, b := range hunk {
+			for ; at < b.first; at++ {
+				unchanged(at)
+			}
+
+			old := file.Source[lines[b.first][0]:lines[b.first][0]]
+			if b.last >= b.first {
+				old = file.Source[lines[b.first][0]:lines[b.last][1]]
+			}
+			changed := apply(old, b.edits, lines[b.first][0])
+			for _, line := range splitLines(string(old)) {
+				writeLine(&body, '-', line)
+				oldCount++
+			}
+			for _, line := range splitLines(string(changed)) {
+				writeLine(&body, '+', line)
+				newCount++
+			}
+			at = b.last + 1
+		}
+		for ; at <= last; at++ {
+			unchanged(at)
+		}
+
+		oldStart, newStart := first+1, first+1+delta
+		if oldCount == 0 {
+			oldStart--
+		}
+		if newCount == 0 {
+			newStart--
+		}
+		fmt.Fprintf(&r, "@@ -%d,%d +%d,%d @@\n", oldStart, oldCount, newStart, newCount)
+		r.WriteString(body.String())
+		delta += newCount - oldCount
+	}
+	return r.String()
+}
+
+// lineRanges returns offsets of lines including line endings,
+// the last range is empty when source ends with a line ending.
+func lineRanges(source []byte) [][2]int {
+	var lines [][2]int
+	start := 0
+	for i := 0; i < len(source); i++ {
+		c := source[i]
+		if c != '\r' && c != '\n' {
+			continue
+		}
+		if i+1 < len(source) && (source[i+1] == '\r' || source[i+1] == '\n') && source[i+1] != c {
+			i++
+		}
+		lines = append(lines, [2]int{start, i + 1})
+		start = i + 1
+	}
+	return append(lines, [2]int{start, len(source)})
+}
+
+// lineOf returns the index of the line containing offset
+func lineOf(lines [][2]int, offset int) int {
+	return sort.Search(len(lines), func(i int) bool { return lines[i][1] > offset || i == len(lines)-1 })
+}
+
+func (file *File) line(lines [][2]int, i int) string {
+	return string(file.Source[lines[i][0]:lines[i][1]])
+}
+
+// splitLines splits s after line endings
+func splitLines(s string) []string {
+	var lines []string
+	for _, r := range lineRanges([]byte(s)) {
+		if r[0] < r[1] {
+			lines = append(lines, s[r[0]:r[1]])
+		}
+	}
+	return lines
+}
+
+// writeLine writes a diff line, marking a missing line ending
+func writeLine(w *strings.Builder, op byte, line string) {
+	w.WriteByte(op)
+	trimmed := strings.TrimRight(line, "\r\n")
+	w.WriteString(trimmed + "\n")
+	if trimmed == line {
+		w.WriteString("\\ No newline at end of file\n")
+	}
+}
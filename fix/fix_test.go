@@ -0,0 +1,162 @@
+package fix_test
+
+import (
+	"testing"
+
+	"github.com/loov/mark"
+	"github.com/loov/mark/fix"
+	"github.com/loov/mark/lint"
+)
+
+func check(fs mark.VirtualDir, path string) []error {
+	seq, errs := mark.ParseFile(fs, path)
+	return append(errs, lint.Lint(fs, seq)...)
+}
+
+func TestApply(t *testing.T) {
+	type Case struct {
+		In  string
+		Exp string
+	}
+
+	cases := []Case{{
+		In:  "###Title\n\nText",
+		Exp: "### Title\n\nText",
+	}, {
+		In:  "Title\n=====\n\nSub {#sub}\n---\n\nText",
+		Exp: "# Title\n\n## Sub {#sub}\n\nText",
+	}, {
+		In:  "Foo #\n===\n\nC#\n---\n\n##\n---",
+		Exp: "# Foo \\#\n\n## C#\n\n## \\##",
+	}, {
+		In:  "* a\n* b\n\n- c\n+ d\n",
+		Exp: "* a\n* b\n\n* c\n* d\n",
+	}, {
+		In:  "Text \t\nmore  \n",
+		Exp: "Text\nmore\n",
+	}, {
+		In:  "```go\nfunc main() {}",
+		Exp: "```go\nfunc main() {}\n```",
+	}, {
+		In:  "  ~~~~\nA\n\n",
+		Exp: "  ~~~~\nA\n\n  ~~~~\n",
+	}, {
+		In:  "Text\r\n##Title \r\n",
+		Exp: "Text\r\n## Title\r\n",
+	}}
+
+	for i, test := range cases {
+		fs := mark.VirtualDir{"main.md": test.In}
+		files, err := fix.Collect(fs, check(fs, "main.md"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(files) != 1 {
+			t.Errorf("#%d: expected a single file, got %d", i, len(files))
+			continue
+		}
+		got := string(files[0].Apply())
+		if got != test.Exp {
+			t.Errorf("#%d: %q\ngot %q\nexp %q", i, test.In, got, test.Exp)
+			continue
+		}
+
+		fs["main.md"] = got
+		if files, _ := fix.Collect(fs, check(fs, "main.md")); len(files) != 0 {
+			t.Errorf("#%d: fixed file is not clean %q", i, files[0].Apply())
+		}
+	}
+}
+
+func TestConflicts(t *testing.T) {
+	fs := mark.VirtualDir{"main.md": "Title  \n===\n"}
+	errs := check(fs, "main.md")
+
+	files, err := fix.Collect(fs, errs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(errs) != 2 {
+		t.Fatalf("expected setext and trailing whitespace, got %v", errs)
+	}
+	if len(files) != 1 || len(files[0].Fixed) != 1 {
+		t.Fatalf("expected a single fix, got %v", files)
+	}
+	if got, exp := string(files[0].Apply()), "# Title\n"; got != exp {
+		t.Errorf("got %q exp %q", got, exp)
+	}
+}
+
+func TestSuppressed(t *testing.T) {
+	fs := mark.VirtualDir{"main.md": "<!-- mark-ignore -->\n###Title"}
+	parser := &mark.Parser{KeepSuppressed: true}
+	_, errs := parser.ParseFile(fs, "main.md")
+	if len(errs) != 1 {
+		t.Fatalf("expected a suppressed error, got %v", errs)
+	}
+	if files, _ := fix.Collect(fs, errs); len(files) != 0 {
+		t.Errorf("suppressed diagnostics should not be fixed")
+	}
+}
+
+func TestDiff(t *testing.T) {
+	type Case struct {
+		In  string
+		Exp string
+	}
+
+	cases := []Case{{
+		In: "###Title\n\n1\n2\n3\n4\n5\n6\n7\n8\n##Other\n",
+		Exp: "--- a/main.md\n+++ b/main.md\n" +
+			"@@ -1,4 +1,4 @@\n" +
+			"-###Title\n" +
+			"+### Title\n" +
+			" \n" +
+			" 1\n" +
+			" 2\n" +
+			"@@ -8,4 +8,4 @@\n" +
+			" 6\n" +
+			" 7\n" +
+			" 8\n" +
+			"-##Other\n" +
+			"+## Other\n",
+	}, {
+		In: "1\n2\n3\n4\n\nTitle\n===\n5\n##A\n",
+		Exp: "--- a/main.md\n+++ b/main.md\n" +
+			"@@ -3,7 +3,6 @@\n" +
+			" 3\n" +
+			" 4\n" +
+			" \n" +
+			"-Title\n" +
+			"-===\n" +
+			"+# Title\n" +
+			" 5\n" +
+			"-##A\n" +
+			"+## A\n",
+	}, {
+		In: "```\nA",
+		Exp: "--- a/main.md\n+++ b/main.md\n" +
+			"@@ -1,2 +1,3 @@\n" +
+			" ```\n" +
+			"-A\n" +
+			"\\ No newline at end of file\n" +
+			"+A\n" +
+			"+```\n" +
+			"\\ No newline at end of file\n",
+	}}
+
+	for i, test := range cases {
+		fs := mark.VirtualDir{"main.md": test.In}
+		files, err := fix.Collect(fs, check(fs, "main.md"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(files) != 1 {
+			t.Errorf("#%d: expected a single file, got %d", i, len(files))
+			continue
+		}
+		if got := files[0].Diff(); got != test.Exp {
+			t.Errorf("#%d: got\n%s\nexp\n%s", i, got, test.Exp)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package mark
+
+import (
+	"go/ast"
+	"go/parser"
+	gotoken "go/token"
+	"strings"
+)
+
+// codeDirective handles `{{code path/file.go:Symbol}}`, where Symbol is a
+// top-level declaration `Name` or a method `Type.Method`.
+func codeDirective(ctx *DirectiveContext, arg string) ([]Block, []error) {
+	sep := strings.LastIndex(arg, ":")
+	if sep < 0 {
+		return nil, []error{ErrBadInclude.Errorf("Expected symbol in code include %q", arg)}
+	}
+	file, symbol := strings.TrimSpace(arg[:sep]), strings.TrimSpace(arg[sep+1:])
+	abs := ctx.Abs(file)
+
+	if ctx.FS == nil {
+		return nil, []error{ErrMissingFile.Errorf("Cannot find file %s", abs)}
+	}
+	content, err := ctx.FS.ReadFile(abs)
+	if err != nil {
+		return nil, []error{ErrMissingFile.Errorf("Failed to read file %v: %v", abs, err)}
+	}
+
+	source, err := goDeclSource(abs, content, symbol)
+	if err != nil {
+		return nil, []error{err}
+	}
+
+	source = strings.Replace(source, "\r\n", "\n", -1)
+	return []Block{&Code{
+		Language: "go",
+		Info:     "go",
+		Lines:    strings.Split(source, "\n"),
+	}}, nil
+}
+
+// goDeclSource returns the source text, including the doc comment, of the
+// declaration named by symbol.
+func goDeclSource(filename string, content []byte, symbol string) (string, error) {
+	fset := gotoken.NewFileSet()
+	file, err := parser.ParseFile(fset, filename, content, parser.ParseComments)
+	if err != nil {
+		return "", ErrBadInclude.Errorf("Failed to parse %v: %v", filename, err)
+	}
+
+	text := func(from, to gotoken.Pos) string {
+		return string(content[fset.Position(from).Offset:fset.Position(to).Offset])
+	}
+	withDoc := func(doc *ast.CommentGroup, node ast.Node) string {
+		if doc != nil {
+			return text(doc.Pos(), node.End())
+		}
+		return text(node.Pos(), node.End())
+	}
+
+	recv, name := "", symbol
+	if dot := strings.Index(symbol, "."); dot >= 0 {
+		recv, name = symbol[:dot], symbol[dot+1:]
+	}
+
+	for _, decl := range file.Decls {
+		switch decl := decl.(type) {
+		case *ast.FuncDecl:
+			if decl.Name.Name == name && receiverName(decl) == recv {
+				return withDoc(decl.Doc, decl), nil
+			}
+		case *ast.GenDecl:
+			if recv != "" {
+				continue
+			}
+			for _, spec := range decl.Specs {
+				if !specDeclares(spec, name) {
+					continue
+				}
+				if !decl.Lparen.IsValid() {
+					return withDoc(decl.Doc, decl), nil
+				}
+
+				// spec inside a group, e.g. `type ( ... )`
+				var doc *ast.CommentGroup
+				switch spec := spec.(type) {
+				case *ast.TypeSpec:
+					doc = spec.Doc
+				case *ast.ValueSpec:
+					doc = spec.Doc
+				}
+				start := spec.Pos()
+				if doc != nil {
+					start = doc.Pos()
+				}
+
+				lines := strings.Split(text(start, spec.End()), "\n")
+				for i := 1; i < len(lines); i++ {
+					lines[i] = strings.TrimPrefix(lines[i], "\t")
+				}
+				first := strings.Count(text(start, spec.Pos()), "\n")
+				lines[first] = decl.Tok.String() + " " + lines[first]
+				return strings.Join(lines, "\n"), nil
+			}
+		}
+	}
+
+	return "", ErrBadInclude.Errorf("Cannot find declaration %s in %s", symbol, filename)
+}
+
+// receiverName returns the base type name of a method receiver
+func receiverName(decl *ast.FuncDecl) string {
+	if decl.Recv == nil || len(decl.Recv.List) == 0 {
+		return ""
+	}
+	typ := decl.Recv.List[0].Type
+	for {
+		switch t := typ.(type) {
+		case *ast.StarExpr:
+			typ = t.X
+		case *ast.IndexExpr:
+			typ = t.X
+		case *ast.IndexListExpr:
+			typ = t.X
+		case *ast.Ident:
+			return t.Name
+		default:
+			return ""
+		}
+	}
+}
+
+func specDeclares(spec ast.Spec, name string) bool {
+	switch spec := spec.(type) {
+	case *ast.TypeSpec:
+		return spec.Name.Name == name
+	case *ast.ValueSpec:
+		for _, ident := range spec.Names {
+			if ident.Name == name {
+				return true
+			}
+		}
+	}
+	return false
+}
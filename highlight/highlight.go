@@ -0,0 +1,128 @@
+// Package highlight implements syntax highlighting for code blocks.
+//
+// Highlighted code is HTML with tokens wrapped in `<span class="...">`,
+// where the class is one of the Kind values.
+package highlight
+
+import (
+	"html"
+	"strings"
+
+	"github.com/loov/mark"
+)
+
+// Kind is the class of a token
+type Kind string
+
+const (
+	Plain     Kind = ""
+	Keyword   Kind = "kw"
+	Builtin   Kind = "bi"
+	String    Kind = "str"
+	Number    Kind = "num"
+	Comment   Kind = "com"
+	Variable  Kind = "var"
+	Tag       Kind = "tag"
+	Attribute Kind = "attr"
+	Inserted  Kind = "ins"
+	Deleted   Kind = "del"
+	Meta      Kind = "meta"
+)
+
+// Token is a piece of source code
+type Token struct {
+	Kind Kind
+	Text string
+}
+
+// Lexer splits source into tokens, concatenated token texts must equal source.
+type Lexer interface {
+	Tokenize(source string) []Token
+}
+
+// LexerFunc implements Lexer with a function
+type LexerFunc func(source string) []Token
+
+func (fn LexerFunc) Tokenize(source string) []Token { return fn(source) }
+
+var lexers = map[string]Lexer{}
+
+// Register registers lexer for language names, such as "go" or "golang".
+func Register(lexer Lexer, names ...string) {
+	for _, name := range names {
+		lexers[strings.ToLower(name)] = lexer
+	}
+}
+
+// Lookup finds lexer for language, it returns nil for unknown languages.
+func Lookup(language string) Lexer {
+	return lexers[strings.ToLower(language)]
+}
+
+// Tokenize splits source into tokens, unknown languages
+// result in a single Plain token.
+func Tokenize(language, source string) []Token {
+	lexer := Lookup(language)
+	if lexer == nil {
+		return []Token{{Plain, source}}
+	}
+	return lexer.Tokenize(source)
+}
+
+// HTML highlights source and returns escaped html
+func HTML(language, source string) string {
+	return strings.Join(Lines(language, strings.Split(source, "\n")), "\n")
+}
+
+// Code highlights the code block and returns html for each line
+func Code(code *mark.Code) []string {
+	return Lines(code.Language, code.Lines)
+}
+
+// Lines highlights lines and returns html for each line. Tokens spanning
+// multiple lines are split, so that every line contains balanced spans.
+func Lines(language string, lines []string) []string {
+	if len(lines) == 0 {
+		return nil
+	}
+	tokens := Tokenize(language, strings.Join(lines, "\n"))
+
+	result := make([]string, 0, len(lines))
+	var line strings.Builder
+	for _, token := range tokens {
+		parts := strings.Split(token.Text, "\n")
+		for i, part := range parts {
+			if i > 0 {
+				result = append(result, line.String())
+				line.Reset()
+			}
+			if part == "" {
+				continue
+			}
+			if token.Kind == Plain {
+				line.WriteString(html.EscapeString(part))
+			} else {
+				line.WriteString(`<span class="` + string(token.Kind) + `">`)
+				line.WriteString(html.EscapeString(part))
+				line.WriteString(`</span>`)
+			}
+		}
+	}
+	result = append(result, line.String())
+	return result
+}
+
+// tokens collects tokens merging adjacent tokens of the same kind
+type tokens []Token
+
+func (tokens *tokens) add(kind Kind, text string) {
+	if text == "" {
+		return
+	}
+	n := len(*tokens)
+	if n > 0 && (*tokens)[n-1].Kind == kind {
+		(*tokens)[n-1].Text += text
+		return
+	}
+	*tokens = append(*tokens, Token{kind, text})
+}
@@ -0,0 +1,67 @@
+package highlight_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/loov/mark/highlight"
+)
+
+func TestHTML(t *testing.T) {
+	tests := []struct {
+		Lang string
+		In   string
+		Exp  string
+	}{
+		{"unknown", "if <x>", "if &lt;x&gt;"},
+		{"go", `func main() { fmt.Println("hi", 42) } // done`,
+			`<span class="kw">func</span> main() { fmt.Println(<span class="str">&#34;hi&#34;</span>, <span class="num">42</span>) } <span class="com">// done</span>`},
+		{"go", "x := `a\nb`",
+			"x := <span class=\"str\">`a</span>\n<span class=\"str\">b`</span>"},
+		{"go", "var s string = nil",
+			`<span class="kw">var</span> s <span class="bi">string</span> = <span class="bi">nil</span>`},
+		{"sh", `echo "$HOME" $1 # comment`,
+			`<span class="bi">echo</span> <span class="str">&#34;$HOME&#34;</span> <span class="var">$1</span> <span class="com"># comment</span>`},
+		{"json", `{"a": [1, true, "x"]}`,
+			`{<span class="attr">&#34;a&#34;</span>: [<span class="num">1</span>, <span class="kw">true</span>, <span class="str">&#34;x&#34;</span>]}`},
+		{"yaml", "# c\nkey: value\n- n: 1",
+			"<span class=\"com\"># c</span>\n<span class=\"attr\">key</span>: <span class=\"str\">value</span>\n- <span class=\"attr\">n</span>: <span class=\"num\">1</span>"},
+		{"sql", "SELECT id FROM users -- all",
+			`<span class="kw">SELECT</span> id <span class="kw">FROM</span> users <span class="com">-- all</span>`},
+		{"diff", "@@ -1 +1 @@\n-a\n+b\n c",
+			"<span class=\"meta\">@@ -1 +1 @@</span>\n<span class=\"del\">-a</span>\n<span class=\"ins\">+b</span>\n c"},
+		{"html", `<a href="x">&amp; y</a><!-- c -->`,
+			`<span class="tag">&lt;a</span> <span class="attr">href</span>=<span class="str">&#34;x&#34;</span><span class="tag">&gt;</span><span class="meta">&amp;amp;</span> y<span class="tag">&lt;/a&gt;</span><span class="com">&lt;!-- c --&gt;</span>`},
+	}
+
+	for _, test := range tests {
+		got := highlight.HTML(test.Lang, test.In)
+		if got != test.Exp {
+			t.Errorf("%s %q:\ngot %q\nexp %q", test.Lang, test.In, got, test.Exp)
+		}
+	}
+}
+
+func TestTokenizeRoundtrip(t *testing.T) {
+	source := "package main\n\n/* multi\nline */\nfunc main() {\n\ts := \"unterminated\n}\n"
+	for _, lang := range []string{"go", "sh", "json", "yaml", "sql", "diff", "html"} {
+		var text strings.Builder
+		for _, token := range highlight.Tokenize(lang, source) {
+			text.WriteString(token.Text)
+		}
+		if text.String() != source {
+			t.Errorf("%s: tokens don't match source:\n%q", lang, text.String())
+		}
+	}
+}
+
+func TestRegister(t *testing.T) {
+	highlight.Register(highlight.LexerFunc(func(source string) []highlight.Token {
+		return []highlight.Token{{Kind: highlight.Keyword, Text: source}}
+	}), "custom")
+
+	got := highlight.HTML("Custom", "x")
+	if exp := `<span class="kw">x</span>`; got != exp {
+		t.Errorf("got %q exp %q", got, exp)
+	}
+}
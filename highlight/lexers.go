@@ -0,0 +1,448 @@
+package highlight
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+func init() {
+	Register(golang, "go", "golang")
+	Register(shell, "sh", "bash", "shell", "console", "zsh")
+	Register(LexerFunc(tokenizeJSON), "json")
+	Register(LexerFunc(tokenizeYAML), "yaml", "yml")
+	Register(sql, "sql")
+	Register(LexerFunc(tokenizeDiff), "diff", "patch")
+	Register(LexerFunc(tokenizeHTML), "html", "xml", "svg")
+}
+
+// clike is a configurable lexer for languages with identifiers,
+// quoted strings and comments.
+type clike struct {
+	keywords     map[string]bool
+	builtins     map[string]bool
+	ignoreCase   bool
+	lineComments []string
+	blockComment [2]string
+	quotes       string // string delimiters
+	rawQuotes    string // string delimiters without escapes spanning lines
+	variables    bool   // shell `$name`
+	identRunes   string // additional runes allowed in identifiers
+}
+
+func words(s string) map[string]bool {
+	m := map[string]bool{}
+	for _, word := range strings.Fields(s) {
+		m[word] = true
+	}
+	return m
+}
+
+var golang = &clike{
+	keywords: words(`break case chan const continue default defer else fallthrough
+		for func go goto if import interface map package range return select
+		struct switch type var`),
+	builtins: words(`append cap clear close complex copy delete imag len make max min
+		new panic print println real recover bool byte complex64 complex128 error
+		float32 float64 int int8 int16 int32 int64 rune string uint uint8 uint16
+		uint32 uint64 uintptr any comparable true false iota nil`),
+	lineComments: []string{"//"},
+	blockComment: [2]string{"/*", "*/"},
+	quotes:       `"'`,
+	rawQuotes:    "`",
+}
+
+var shell = &clike{
+	keywords: words(`if then else elif fi for while until do done case esac in
+		function return local export select break continue`),
+	builtins: words(`echo cd pwd exit set unset read source test alias eval exec
+		shift trap wait printf cat grep sed awk ls mkdir rm cp mv go git make`),
+	lineComments: []string{"#"},
+	quotes:       `"`,
+	rawQuotes:    `'`,
+	variables:    true,
+	identRunes:   "-",
+}
+
+var sql = &clike{
+	keywords: words(`select from where and or not insert into values update set
+		delete create table drop alter add index primary key foreign references
+		join inner left right outer full on group by order asc desc having limit
+		offset as distinct union all exists in is null like between case when then
+		else end begin commit rollback transaction view default unique constraint
+		if with returning`),
+	builtins: words(`int integer bigint smallint text varchar char boolean bool
+		date time timestamp timestamptz serial float real double numeric decimal
+		count sum avg min max coalesce now true false`),
+	ignoreCase:   true,
+	lineComments: []string{"--"},
+	blockComment: [2]string{"/*", "*/"},
+	quotes:       `'"`,
+}
+
+func (lang *clike) Tokenize(source string) []Token {
+	var result tokens
+	for i := 0; i < len(source); {
+		rest := source[i:]
+		r, size := utf8.DecodeRuneInString(rest)
+
+		if n := lang.comment(rest); n > 0 {
+			result.add(Comment, rest[:n])
+			i += n
+			continue
+		}
+		if strings.ContainsRune(lang.quotes, r) {
+			n := scanString(rest, r, true)
+			result.add(String, rest[:n])
+			i += n
+			continue
+		}
+		if strings.ContainsRune(lang.rawQuotes, r) {
+			n := scanString(rest, r, false)
+			result.add(String, rest[:n])
+			i += n
+			continue
+		}
+		if lang.variables && r == '$' {
+			n := scanVariable(rest)
+			result.add(Variable, rest[:n])
+			i += n
+			continue
+		}
+		if isDigit(r) {
+			n := scanNumber(rest)
+			result.add(Number, rest[:n])
+			i += n
+			continue
+		}
+		if isIdentStart(r) {
+			n := lang.scanIdent(rest)
+			word := rest[:n]
+			if lang.ignoreCase {
+				word = strings.ToLower(word)
+			}
+			switch {
+			case lang.keywords[word]:
+				result.add(Keyword, rest[:n])
+			case lang.builtins[word]:
+				result.add(Builtin, rest[:n])
+			default:
+				result.add(Plain, rest[:n])
+			}
+			i += n
+			continue
+		}
+
+		result.add(Plain, rest[:size])
+		i += size
+	}
+	return result
+}
+
+// comment returns the length of a comment at the start of s
+func (lang *clike) comment(s string) int {
+	for _, prefix := range lang.lineComments {
+		if strings.HasPrefix(s, prefix) {
+			if end := strings.IndexByte(s, '\n'); end >= 0 {
+				return end
+			}
+			return len(s)
+		}
+	}
+	if start := lang.blockComment[0]; start != "" && strings.HasPrefix(s, start) {
+		if end := strings.Index(s[len(start):], lang.blockComment[1]); end >= 0 {
+			return len(start) + end + len(lang.blockComment[1])
+		}
+		return len(s)
+	}
+	return 0
+}
+
+func (lang *clike) scanIdent(s string) int {
+	for i, r := range s {
+		if !isIdentStart(r) && !isDigit(r) && !strings.ContainsRune(lang.identRunes, r) {
+			return i
+		}
+	}
+	return len(s)
+}
+
+// scanString returns the length of a string starting with quote,
+// escaped strings end at a line break.
+func scanString(s string, quote rune, escapes bool) int {
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			if escapes {
+				i++
+			}
+		case '\n':
+			if escapes {
+				return i
+			}
+		case byte(quote):
+			return i + 1
+		}
+	}
+	return len(s)
+}
+
+// scanVariable returns the length of `$name`, `${name}` or `$1`
+func scanVariable(s string) int {
+	if strings.HasPrefix(s, "${") {
+		if end := strings.IndexByte(s, '}'); end >= 0 {
+			return end + 1
+		}
+		return len(s)
+	}
+	for i, r := range s[1:] {
+		if !isIdentStart(r) && !isDigit(r) {
+			if i == 0 && strings.ContainsRune("?@#*!$-", r) {
+				return 2
+			}
+			return i + 1
+		}
+	}
+	return len(s)
+}
+
+func scanNumber(s string) int {
+	for i, r := range s {
+		if !isDigit(r) && !isIdentStart(r) && r != '.' {
+			return i
+		}
+	}
+	return len(s)
+}
+
+func isDigit(r rune) bool      { return '0' <= r && r <= '9' }
+func isIdentStart(r rune) bool { return r == '_' || unicode.IsLetter(r) }
+
+// tokenizeJSON highlights strings, numbers and literals; object keys are attributes
+func tokenizeJSON(source string) []Token {
+	var result tokens
+	for i := 0; i < len(source); {
+		rest := source[i:]
+		r, size := utf8.DecodeRuneInString(rest)
+		switch {
+		case r == '"':
+			n := scanString(rest, r, true)
+			kind := String
+			if strings.HasPrefix(strings.TrimLeft(rest[n:], " \t\r\n"), ":") {
+				kind = Attribute
+			}
+			result.add(kind, rest[:n])
+			i += n
+		case r == '-' || isDigit(r):
+			n := 1 + scanNumber(rest[1:])
+			result.add(Number, rest[:n])
+			i += n
+		case isIdentStart(r):
+			n := scanNumber(rest)
+			switch rest[:n] {
+			case "true", "false", "null":
+				result.add(Keyword, rest[:n])
+			default:
+				result.add(Plain, rest[:n])
+			}
+			i += n
+		default:
+			result.add(Plain, rest[:size])
+			i += size
+		}
+	}
+	return result
+}
+
+// tokenizeYAML highlights comments, keys, scalars and document markers
+func tokenizeYAML(source string) []Token {
+	var result tokens
+	lines := strings.SplitAfter(source, "\n")
+	for _, line := range lines {
+		content := strings.TrimRight(line, "\r\n")
+		ending := line[len(content):]
+
+		trimmed := strings.TrimLeft(content, " \t")
+		indent := content[:len(content)-len(trimmed)]
+		result.add(Plain, indent)
+
+		switch {
+		case strings.HasPrefix(trimmed, "#"):
+			result.add(Comment, trimmed)
+			result.add(Plain, ending)
+			continue
+		case trimmed == "---" || trimmed == "...":
+			result.add(Meta, trimmed)
+			result.add(Plain, ending)
+			continue
+		}
+
+		for strings.HasPrefix(trimmed, "- ") {
+			result.add(Plain, "- ")
+			trimmed = trimmed[2:]
+		}
+
+		if key := yamlKey(trimmed); key > 0 {
+			result.add(Attribute, trimmed[:key])
+			trimmed = trimmed[key:]
+		}
+		yamlValue(&result, trimmed)
+		result.add(Plain, ending)
+	}
+	return result
+}
+
+// yamlKey returns the length of `key` in `key: value`
+func yamlKey(s string) int {
+	if strings.HasPrefix(s, `"`) || strings.HasPrefix(s, `'`) {
+		n := scanString(s, rune(s[0]), s[0] == '"')
+		if strings.HasPrefix(s[n:], ":") {
+			return n
+		}
+		return 0
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] == ':' && (i+1 == len(s) || s[i+1] == ' ') {
+			return i
+		}
+		if s[i] == '#' || s[i] == '{' || s[i] == '[' {
+			return 0
+		}
+	}
+	return 0
+}
+
+func yamlValue(result *tokens, s string) {
+	for i := 0; i < len(s); {
+		rest := s[i:]
+		switch {
+		case rest[0] == '#' && (i == 0 || s[i-1] == ' '):
+			result.add(Comment, rest)
+			return
+		case rest[0] == '"' || rest[0] == '\'':
+			n := scanString(rest, rune(rest[0]), rest[0] == '"')
+			result.add(String, rest[:n])
+			i += n
+		case rest[0] == ' ' || rest[0] == ':' || strings.IndexByte("[]{},&*!|>", rest[0]) >= 0:
+			result.add(Plain, rest[:1])
+			i++
+		default:
+			n := strings.IndexAny(rest, " ,]}")
+			if n < 0 {
+				n = len(rest)
+			}
+			word := rest[:n]
+			switch {
+			case word == "true" || word == "false" || word == "null" || word == "~" ||
+				word == "yes" || word == "no":
+				result.add(Keyword, word)
+			case isDigit(rune(word[0])) || word[0] == '-' && len(word) > 1 && isDigit(rune(word[1])):
+				result.add(Number, word)
+			default:
+				result.add(String, word)
+			}
+			i += n
+		}
+	}
+}
+
+// tokenizeDiff highlights added, removed and header lines
+func tokenizeDiff(source string) []Token {
+	var result tokens
+	for _, line := range strings.SplitAfter(source, "\n") {
+		content := strings.TrimRight(line, "\r\n")
+		switch {
+		case strings.HasPrefix(content, "+++") || strings.HasPrefix(content, "---") ||
+			strings.HasPrefix(content, "diff ") || strings.HasPrefix(content, "index "):
+			result.add(Meta, content)
+		case strings.HasPrefix(content, "@@"):
+			result.add(Meta, content)
+		case strings.HasPrefix(content, "+"):
+			result.add(Inserted, content)
+		case strings.HasPrefix(content, "-"):
+			result.add(Deleted, content)
+		default:
+			result.add(Plain, content)
+		}
+		result.add(Plain, line[len(content):])
+	}
+	return result
+}
+
+// tokenizeHTML highlights tags, attributes, values, comments and entities
+func tokenizeHTML(source string) []Token {
+	var result tokens
+	for i := 0; i < len(source); {
+		rest := source[i:]
+		switch {
+		case strings.HasPrefix(rest, "<!--"):
+			n := strings.Index(rest, "-->")
+			if n < 0 {
+				n = len(rest)
+			} else {
+				n += 3
+			}
+			result.add(Comment, rest[:n])
+			i += n
+		case strings.HasPrefix(rest, "<") && len(rest) > 1 &&
+			(rest[1] == '/' || rest[1] == '!' || rest[1] == '?' || isIdentStart(rune(rest[1]))):
+			i += htmlTag(&result, rest)
+		case rest[0] == '&':
+			n := strings.IndexByte(rest, ';')
+			if n < 2 || n > 10 || strings.ContainsAny(rest[1:n], " <&\n") {
+				result.add(Plain, "&")
+				i++
+				continue
+			}
+			result.add(Meta, rest[:n+1])
+			i += n + 1
+		default:
+			n := strings.IndexAny(rest[1:], "<&")
+			if n < 0 {
+				n = len(rest)
+			} else {
+				n++
+			}
+			result.add(Plain, rest[:n])
+			i += n
+		}
+	}
+	return result
+}
+
+// htmlTag tokenizes a tag and returns its length
+func htmlTag(result *tokens, s string) int {
+	i := 1
+	for i < len(s) && (s[i] == '/' || s[i] == '!' || s[i] == '?') {
+		i++
+	}
+	for i < len(s) && !strings.ContainsRune(" \t\r\n/>", rune(s[i])) {
+		i++
+	}
+	result.add(Tag, s[:i])
+
+	for i < len(s) {
+		switch c := s[i]; {
+		case c == '>':
+			result.add(Tag, ">")
+			return i + 1
+		case c == '/' || c == '?':
+			result.add(Tag, s[i:i+1])
+			i++
+		case c == '"' || c == '\'':
+			n := scanString(s[i:], rune(c), false)
+			result.add(String, s[i:i+n])
+			i += n
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n' || c == '=':
+			result.add(Plain, s[i:i+1])
+			i++
+		default:
+			start := i
+			for i < len(s) && !strings.ContainsRune(" \t\r\n=/>\"'", rune(s[i])) {
+				i++
+			}
+			result.add(Attribute, s[start:i])
+		}
+	}
+	return len(s)
+}
@@ -5,15 +5,18 @@ import (
 	"fmt"
 	"html"
 	"html/template"
+	"reflect"
+	"sort"
 	"strconv"
 	"strings"
 
 	"github.com/loov/mark"
+	"github.com/loov/mark/highlight"
 )
 
 var (
-	linkTemplate  = template.Must(template.New("").Parse(`<a href="{{.Href}}">{{.Title}}</a>`))
-	imageTemplate = template.Must(template.New("").Parse(`<figure><img src="{{.Href}}" alt="{{.Title}}" title="{{.Title}}">{{if .Title}}<figcaption>{{.Title}}</figcaption>{{end}}</figure>`))
+	linkTemplate  = template.Must(template.New("").Parse(`<a href="{{.Href}}"{{if .Tooltip}} title="{{.Tooltip}}"{{end}}>{{.Title}}</a>`))
+	imageTemplate = template.Must(template.New("").Parse(`<figure><img src="{{.Href}}" alt="{{.Title}}" title="{{if .Tooltip}}{{.Tooltip}}{{else}}{{.Title}}{{end}}">{{if .Title}}<figcaption>{{.Title}}</figcaption>{{end}}</figure>`))
 )
 
 func exec(t *template.Template, data interface{}) string {
@@ -25,22 +28,30 @@ func exec(t *template.Template, data interface{}) string {
 	return buf.String()
 }
 
+var inlineRenderers = map[reflect.Type]func(mark.Inline) string{}
+
+// RegisterInline registers a renderer for custom inline nodes
+// of the same type as node.
+func RegisterInline(node mark.Inline, render func(mark.Inline) string) {
+	inlineRenderers[reflect.TypeOf(node)] = render
+}
+
 func ConvertInline(inline mark.Inline) (r string) {
 	switch el := inline.(type) {
 	case mark.Text:
-		return html.EscapeString(string(el))
+		return html.EscapeString(el.Value)
 	case mark.Emphasis:
-		for _, x := range el {
+		for _, x := range el.Items {
 			r += ConvertInline(x)
 		}
 		return "<em>" + r + "</em>"
 	case mark.Bold:
-		for _, x := range el {
+		for _, x := range el.Items {
 			r += ConvertInline(x)
 		}
 		return "<b>" + r + "</b>"
 	case mark.CodeSpan:
-		x := html.EscapeString(string(el))
+		x := html.EscapeString(el.Value)
 		return "<code>" + x + "</code>"
 	case mark.SoftBreak:
 		return "\n"
@@ -48,15 +59,20 @@ func ConvertInline(inline mark.Inline) (r string) {
 		return "<br>"
 	case mark.Link:
 		return exec(linkTemplate, map[string]interface{}{
-			"Href":  el.Href,
-			"Title": template.HTML(ConvertParagraph(&el.Title)),
+			"Href":    el.Href,
+			"Title":   template.HTML(ConvertParagraph(&el.Title)),
+			"Tooltip": el.Tooltip,
 		})
 	case mark.Image:
 		return exec(imageTemplate, map[string]interface{}{
-			"Href":  el.Href,
-			"Title": template.HTML(ConvertParagraph(&el.Alt)),
+			"Href":    el.Href,
+			"Title":   template.HTML(ConvertParagraph(&el.Alt)),
+			"Tooltip": el.Tooltip,
 		})
 	default:
+		if render, ok := inlineRenderers[reflect.TypeOf(inline)]; ok {
+			return render(inline)
+		}
 		panic(fmt.Errorf("unimplemented: %#+v", inline))
 	}
 }
@@ -82,16 +98,26 @@ func ConvertBlock(block mark.Block) (r string) {
 		}
 		return starttag + r + "</div>"
 	case *mark.Code:
-		starttag := "<pre><code>"
+		attrs := ""
 		if el.Language != "" {
-			starttag = "<pre><code class=\"language-" +
-				template.JSEscapeString(el.Language) +
-				"\">"
+			attrs += " class=\"language-" + template.JSEscapeString(el.Language) + "\""
+		}
+		keys := make([]string, 0, len(el.Attrs))
+		for key := range el.Attrs {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			if name := attributeName(key); name != "" {
+				attrs += " data-" + name + "=\"" + html.EscapeString(el.Attrs[key]) + "\""
+			}
 		}
 
-		return starttag +
-			html.EscapeString(strings.Join(el.Lines, "\n")) +
-			"</code></pre>"
+		r = "<pre><code" + attrs + ">" + ConvertCodeLines(el) + "</code></pre>"
+		if el.Title != "" {
+			r = "<figure class=\"code\"><figcaption>" + html.EscapeString(el.Title) + "</figcaption>" + r + "</figure>"
+		}
+		return r
 	case *mark.Paragraph:
 		return "<p>" + ConvertParagraph(el) + "</p>"
 	case *mark.Separator:
@@ -134,6 +160,45 @@ func ConvertBlock(block mark.Block) (r string) {
 	}
 }
 
+// ConvertCodeLines converts the content of a code block
+// with syntax highlighting, see package highlight.
+//
+// With line numbers or highlighted lines, each line is wrapped in
+// `<span class="line">`. Numbers are in the `data-line` attribute, so they
+// can be displayed with `.line::before { content: attr(data-line) }` without
+// becoming part of copied text. Highlighted lines have class `hl`.
+func ConvertCodeLines(el *mark.Code) string {
+	highlighted := highlight.Code(el)
+	if !el.LineNumbers && len(el.Highlight) == 0 {
+		return strings.Join(highlighted, "\n")
+	}
+
+	lines := make([]string, len(highlighted))
+	for i, line := range highlighted {
+		class := "line"
+		if el.Highlighted(el.LineNumber(i)) {
+			class += " hl"
+		}
+		number := ""
+		if el.LineNumbers {
+			number = " data-line=\"" + strconv.Itoa(el.LineNumber(i)) + "\""
+		}
+		lines[i] = "<span class=\"" + class + "\"" + number + ">" + line + "</span>"
+	}
+	return strings.Join(lines, "\n")
+}
+
+// attributeName converts key into a safe attribute name
+func attributeName(key string) string {
+	name := strings.TrimPrefix(strings.ToLower(key), "data-")
+	for _, r := range name {
+		if !('a' <= r && r <= 'z' || '0' <= r && r <= '9' || r == '-' || r == '_') {
+			return ""
+		}
+	}
+	return name
+}
+
 func Convert(seq mark.Sequence) string {
 	return ConvertBlock(&seq)
 }
@@ -0,0 +1,73 @@
+package html_test
+
+import (
+	"testing"
+
+	"github.com/loov/mark"
+	"github.com/loov/mark/html"
+)
+
+func TestConvertCode(t *testing.T) {
+	tests := []struct {
+		In  string
+		Exp string
+	}{{
+		In:  "```go\nA\n```",
+		Exp: `<pre><code class="language-go">A</code></pre>`,
+	}, {
+		In:  "```go title=\"main.go\" x=<y>\nA\n```",
+		Exp: `<figure class="code"><figcaption>main.go</figcaption><pre><code class="language-go" data-x="&lt;y&gt;">A</code></pre></figure>`,
+	}, {
+		In: "``` {2} linenos\nA\n<B>\n```",
+		Exp: `<pre><code><span class="line" data-line="1">A</span>` + "\n" +
+			`<span class="line hl" data-line="2">&lt;B&gt;</span></code></pre>`,
+	}, {
+		In: "``` linenos=10 {11}\nA\nB\n```",
+		Exp: `<pre><code><span class="line" data-line="10">A</span>` + "\n" +
+			`<span class="line hl" data-line="11">B</span></code></pre>`,
+	}, {
+		In: "``` linenos=0 {1}\nA\nB\n```",
+		Exp: `<pre><code><span class="line" data-line="0">A</span>` + "\n" +
+			`<span class="line hl" data-line="1">B</span></code></pre>`,
+	}, {
+		In: "```go linenos\nfunc main() {\n}\n```",
+		Exp: `<pre><code class="language-go"><span class="line" data-line="1"><span class="kw">func</span> main() {</span>` + "\n" +
+			`<span class="line" data-line="2">}</span></code></pre>`,
+	}, {
+		In:  "``` {1}\nA\n```",
+		Exp: `<pre><code><span class="line hl">A</span></code></pre>`,
+	}}
+
+	for _, test := range tests {
+		seq, errs := mark.ParseContent(nil, "main.md", []byte(test.In))
+		if len(errs) > 0 {
+			t.Errorf("%q: %v", test.In, errs)
+			continue
+		}
+		if got := html.Convert(seq); got != test.Exp {
+			t.Errorf("%q:\ngot %q\nexp %q", test.In, got, test.Exp)
+		}
+	}
+}
+
+func TestConvertCodeLines(t *testing.T) {
+	// lines constructed without parsing may contain line breaks
+	code := &mark.Code{LineNumbers: true, Lines: []string{"A\nB", "C"}}
+	got := html.ConvertCodeLines(code)
+	exp := `<span class="line" data-line="1">A</span>` + "\n" +
+		`<span class="line" data-line="2">B</span>` + "\n" +
+		`<span class="line" data-line="3">C</span>`
+	if got != exp {
+		t.Errorf("got %q\nexp %q", got, exp)
+	}
+}
+
+func FuzzConvert(f *testing.F) {
+	f.Add("# Title\n\nPara *em* **bold** `code` [link](http://example.com \"t\")")
+	f.Add("> quote\n\n* item\n\n```go {1} linenos title=x\nfunc main() {}\n```")
+	f.Add("![<i>](\"i.png\" '\"') {warning}\n<script>")
+	f.Fuzz(func(t *testing.T, content string) {
+		seq, _ := mark.ParseContent(nil, "main.md", []byte(content))
+		html.Convert(seq)
+	})
+}
@@ -0,0 +1,122 @@
+package mark
+
+import "strings"
+
+// Diagnostics can be suppressed with comments:
+//
+//	<!-- mark-ignore missing-file -->
+//	See [the result](build/output.html) after following the steps.
+//
+// `mark-ignore` applies to the next block, `mark-ignore-file` applies
+// to the whole file. Without codes all diagnostics are suppressed.
+// Diagnostics from included files are suppressed by comments
+// that apply to the include.
+
+// ignore is a `<!-- mark-ignore code -->` comment
+type ignore struct {
+	codes    []ErrorCode // empty matches all codes
+	from, to int         // lines, 0 for the whole file
+}
+
+// startsIgnore checks whether line is a `<!-- mark-ignore -->` comment
+func (line line) startsIgnore() bool {
+	text := strings.TrimSpace(string(line))
+	return strings.HasPrefix(text, "<!-- mark-ignore") && strings.HasSuffix(text, "-->")
+}
+
+// ignoreComment handles `<!-- mark-ignore -->` lines
+func (parse *parse) ignoreComment() {
+	parse.flushParagraph()
+
+	text := strings.TrimSpace(string(parse.reader.line()))
+	text = strings.TrimSuffix(strings.TrimPrefix(text, "<!--"), "-->")
+	fields := strings.Fields(text)
+
+	ignore := &ignore{}
+	for _, field := range fields[1:] {
+		ignore.codes = append(ignore.codes, ErrorCode(field))
+	}
+
+	switch fields[0] {
+	case "mark-ignore":
+		parse.pendingIgnore = ignore
+	case "mark-ignore-file":
+		parse.ignores = append(parse.ignores, ignore)
+	default:
+		parse.check(ErrSyntax.Errorf("Unknown comment directive %s", fields[0]))
+	}
+}
+
+// beginIgnore starts a pending ignore at the first line of the next block
+func (parse *parse) beginIgnore() {
+	if parse.pendingIgnore != nil && parse.pendingIgnore.from == 0 {
+		parse.pendingIgnore.from = parse.reader.head.line
+	}
+}
+
+// endIgnore ends a started pending ignore at line
+func (parse *parse) endIgnore(line int) {
+	if parse.pendingIgnore == nil || parse.pendingIgnore.from == 0 {
+		return
+	}
+	parse.pendingIgnore.to = line
+	parse.ignores = append(parse.ignores, parse.pendingIgnore)
+	parse.pendingIgnore = nil
+}
+
+// applyIgnores removes or marks suppressed diagnostics
+func (parse *parse) applyIgnores() {
+	parse.endIgnore(parse.reader.head.line)
+	if len(parse.ignores) == 0 {
+		return
+	}
+
+	errs := parse.errors[:0]
+	for _, err := range parse.errors {
+		diag, ok := err.(*ParseError)
+		if ok && !diag.Suppressed && parse.ignored(diag) {
+			if !parse.book.KeepSuppressed {
+				continue
+			}
+			diag.Suppressed = true
+		}
+		errs = append(errs, err)
+	}
+	parse.errors = errs
+}
+
+// ignored checks whether diagnostic is suppressed in the current file
+func (parse *parse) ignored(diag *ParseError) bool {
+	line := 0
+	if diag.Path == parse.path {
+		line = diag.Line
+	} else {
+		for i := len(diag.Stack) - 1; i >= 0; i-- {
+			if diag.Stack[i].Path == parse.path {
+				line = diag.Stack[i].Line
+				break
+			}
+		}
+	}
+	if line == 0 {
+		return false
+	}
+
+	for _, ignore := range parse.ignores {
+		if ignore.from != 0 {
+			// lines of virtual content don't match the lines of diagnostics
+			if parse.virtual || line < ignore.from || ignore.to < line {
+				continue
+			}
+		}
+		if len(ignore.codes) == 0 {
+			return true
+		}
+		for _, code := range ignore.codes {
+			if code == diag.Code {
+				return true
+			}
+		}
+	}
+	return false
+}
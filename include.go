@@ -0,0 +1,315 @@
+package mark
+
+import (
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// includeDirective handles `{{path args...}}` lines.
+//
+// The path may be a single file, a glob pattern such as `chapters/*.md`, or a
+// directory ending with `/`, which includes all `*.md` files inside it.
+// Matched files are included in sorted order; `order=numeric` compares
+// leading numbers numerically so that `2-setup.md` comes before `10-end.md`.
+//
+// `level=` shifts headings of the included files: `level=+1` and `level=-1`
+// shift relative to the file, `level=2` moves the top headings to `##` and
+// `level=nest` places them under the current section.
+//
+// `{{file.md#id}}` includes only the section with the matching ID or slug,
+// placed under the current section unless `level=` is specified.
+func includeDirective(ctx *DirectiveContext, arg string) ([]Block, []error) {
+	file, section, args := splitIncludeArgs(arg)
+	if _, ok := args["level"]; section != "" && !ok {
+		args["level"] = "nest"
+	}
+	isdir := strings.HasSuffix(file, "/")
+	abs := ctx.Abs(file)
+
+	if !isdir && !isGlobPattern(abs) {
+		return includeFile(ctx, abs, section, args)
+	}
+	if isdir {
+		abs = path.Join(abs, "*.md")
+	}
+
+	var less func(a, b string) bool
+	switch order := args["order"]; order {
+	case "", "name":
+		less = func(a, b string) bool { return a < b }
+	case "numeric":
+		less = lessNumeric
+	default:
+		return nil, []error{ErrBadInclude.Errorf("Unknown include order %q", order)}
+	}
+
+	if ctx.FS == nil {
+		return nil, []error{ErrMissingFile.Errorf("Cannot find files %v", abs)}
+	}
+	files, err := glob(ctx.FS, abs)
+	if err != nil {
+		return nil, []error{ErrMissingFile.Errorf("Failed to list files %v: %v", abs, err)}
+	}
+	if len(files) == 0 {
+		return nil, []error{ErrMissingFile.Errorf("No files match %v", abs)}
+	}
+
+	sort.Slice(files, func(i, k int) bool { return less(files[i], files[k]) })
+
+	var blocks []Block
+	var errs []error
+	for _, file := range files {
+		fileblocks, fileerrs := includeFile(ctx, file, section, args)
+		blocks = append(blocks, fileblocks...)
+		errs = append(errs, fileerrs...)
+	}
+	return blocks, errs
+}
+
+// includeFile parses file and applies the section and level arguments,
+// section is "" when including the whole file.
+func includeFile(ctx *DirectiveContext, abs, section string, args map[string]string) ([]Block, []error) {
+	seq, errs := ctx.ParseFile(abs)
+
+	if section != "" {
+		found, available := findSection(seq, section)
+		if found == nil {
+			return nil, append(errs, ErrBadInclude.Errorf("Cannot find section %q in %v, available: %v", section, abs, strings.Join(available, ", ")))
+		}
+		seq = Sequence{found}
+	}
+
+	if level, ok := args["level"]; ok {
+		if err := shiftLevels(seq, level, ctx.Level()); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return seq, errs
+}
+
+// shiftLevels changes levels of all sections in seq as specified by `level=`,
+// current is the level of the section containing seq.
+func shiftLevels(seq Sequence, level string, current int) error {
+	top := minSectionLevel(seq)
+	if top == 0 {
+		return nil
+	}
+
+	var shift int
+	switch {
+	case level == "nest":
+		shift = current + 1 - top
+	case strings.HasPrefix(level, "+") || strings.HasPrefix(level, "-"):
+		n, err := strconv.Atoi(level)
+		if err != nil {
+			return ErrBadInclude.Errorf("Invalid include level %q", level)
+		}
+		shift = n
+	default:
+		n, err := strconv.Atoi(level)
+		if err != nil || !order(1, n, 6) {
+			return ErrBadInclude.Errorf("Invalid include level %q", level)
+		}
+		shift = n - top
+	}
+	if shift == 0 {
+		return nil
+	}
+
+	var err error
+	walkSections(seq, func(sec *Section) {
+		sec.Level += shift
+		if !order(1, sec.Level, 6) {
+			if err == nil {
+				err = ErrBadInclude.Errorf("Shifting headings by %+d results in level %d", shift, sec.Level)
+			}
+			if sec.Level < 1 {
+				sec.Level = 1
+			} else {
+				sec.Level = 6
+			}
+		}
+	})
+	return err
+}
+
+// findSection finds section with the specified id or slug,
+// when it's missing it returns the list of available ids.
+func findSection(seq Sequence, id string) (found *Section, available []string) {
+	walkSections(seq, func(sec *Section) {
+		slug := sec.Slug()
+		if found == nil && (sec.ID == id || slug == id) {
+			found = sec
+		}
+		available = append(available, slug)
+	})
+	return found, available
+}
+
+// currentLevel returns the level of the innermost open section
+func (parse *parse) currentLevel() int {
+	level := 0
+	seq := parse.sequence
+	for len(seq) > 0 {
+		sec, ok := seq[len(seq)-1].(*Section)
+		if !ok {
+			break
+		}
+		level = sec.Level
+		seq = sec.Content
+	}
+	return level
+}
+
+// minSectionLevel returns the smallest level of sections in seq, 0 if there are none
+func minSectionLevel(seq Sequence) int {
+	min := 0
+	walkSections(seq, func(sec *Section) {
+		if min == 0 || sec.Level < min {
+			min = sec.Level
+		}
+	})
+	return min
+}
+
+// walkSections calls fn for every section in seq, including nested sections
+func walkSections(seq Sequence, fn func(sec *Section)) {
+	for _, block := range seq {
+		switch block := block.(type) {
+		case *Section:
+			fn(block)
+			walkSections(block.Content, fn)
+		case *Quote:
+			walkSections(block.Content, fn)
+		case *Modifier:
+			walkSections(block.Content, fn)
+		case *List:
+			for _, item := range block.Content {
+				walkSections(item, fn)
+			}
+		}
+	}
+}
+
+// splitIncludeArgs separates the path, the `#section` fragment and
+// trailing `key=value` arguments
+func splitIncludeArgs(s string) (file, section string, args map[string]string) {
+	args = map[string]string{}
+	fields := strings.Fields(s)
+	for len(fields) > 1 {
+		last := fields[len(fields)-1]
+		eq := strings.Index(last, "=")
+		if eq <= 0 {
+			break
+		}
+		args[last[:eq]] = last[eq+1:]
+		fields = fields[:len(fields)-1]
+	}
+	file = strings.Join(fields, " ")
+	if hash := strings.LastIndex(file, "#"); hash >= 0 {
+		file, section = file[:hash], file[hash+1:]
+	}
+	return file, section, args
+}
+
+func isGlobPattern(p string) bool { return strings.ContainsAny(p, "*?[") }
+
+// lessNumeric compares paths segment by segment, ordering segments with
+// a numeric prefix by its value.
+func lessNumeric(a, b string) bool {
+	as, bs := strings.Split(a, "/"), strings.Split(b, "/")
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		if as[i] == bs[i] {
+			continue
+		}
+		an, arest := numericPrefix(as[i])
+		bn, brest := numericPrefix(bs[i])
+		switch {
+		case an >= 0 && bn >= 0 && an != bn:
+			return an < bn
+		case an >= 0 && bn < 0:
+			return true
+		case an < 0 && bn >= 0:
+			return false
+		case arest != brest:
+			return arest < brest
+		}
+		return as[i] < bs[i]
+	}
+	return len(as) < len(bs)
+}
+
+// numericPrefix returns the value of leading digits, -1 when there are none
+func numericPrefix(s string) (n int, rest string) {
+	i := 0
+	for i < len(s) && '0' <= s[i] && s[i] <= '9' {
+		n = n*10 + int(s[i]-'0')
+		i++
+	}
+	if i == 0 {
+		return -1, s
+	}
+	return n, s[i:]
+}
+
+// glob returns files matching pattern, where each `/` separated segment
+// is matched with path.Match.
+func glob(fs FileSystem, pattern string) ([]string, error) {
+	root := ""
+	if strings.HasPrefix(pattern, "/") {
+		root = "/"
+	}
+	segments := strings.Split(strings.Trim(pattern, "/"), "/")
+
+	candidates := []string{root}
+	for i, segment := range segments {
+		last := i == len(segments)-1
+
+		var next []string
+		for _, dir := range candidates {
+			if !isGlobPattern(segment) {
+				next = append(next, dir+segment+"/")
+				continue
+			}
+
+			listdir := dir
+			if listdir == "" {
+				listdir = "."
+			}
+			names, err := fs.ReadDir(listdir)
+			if err != nil {
+				if i == 0 {
+					return nil, err
+				}
+				continue
+			}
+			for _, name := range names {
+				isdir := strings.HasSuffix(name, "/")
+				if last && isdir {
+					continue
+				}
+				if ok, err := path.Match(segment, strings.TrimSuffix(name, "/")); err != nil {
+					return nil, err
+				} else if ok {
+					next = append(next, dir+strings.TrimSuffix(name, "/")+"/")
+				}
+			}
+		}
+		candidates = next
+	}
+
+	files := make([]string, 0, len(candidates))
+	for _, candidate := range candidates {
+		file := strings.TrimSuffix(candidate, "/")
+		if !isGlobPattern(segments[len(segments)-1]) {
+			if err := fs.FileExists(file); err != nil {
+				continue
+			}
+		}
+		files = append(files, file)
+	}
+	return files, nil
+}
@@ -2,6 +2,7 @@ package mark
 
 type Inline interface {
 	TagInline()
+	Pos() Position
 }
 
 func (Text) TagInline()      {}
@@ -12,22 +13,34 @@ func (SoftBreak) TagInline() {}
 func (HardBreak) TagInline() {}
 
 // Text is plain-text
-type Text string
+type Text struct {
+	Position
+	Value string
+}
 
 // Emphasis is text that should appear emphasised `<em>`
-type Emphasis []Inline
+type Emphasis struct {
+	Position
+	Items []Inline
+}
 
 // Bold is text that should appear bold `<b>`
-type Bold []Inline
+type Bold struct {
+	Position
+	Items []Inline
+}
 
 // CodeSpan is text that should appear monospaced `<code>`
-type CodeSpan string
+type CodeSpan struct {
+	Position
+	Value string
+}
 
 // SoftBreak is a soft line break
-type SoftBreak struct{}
+type SoftBreak struct{ Position }
 
 // HardBreak is a hard line break
-type HardBreak struct{}
+type HardBreak struct{ Position }
 
 func (Callout) TagInline() {}
 func (Index) TagInline()   {}
@@ -35,30 +48,41 @@ func (Image) TagInline()   {}
 func (Link) TagInline()    {}
 
 // Callout is an element that indicates relation to some other callout `<span class="callout">`
-type Callout string
+type Callout struct {
+	Position
+	Value string
+}
 
 // Index is a hidden point that word-index can link to `<span class="index">`
-type Index struct{ Term string }
+type Index struct {
+	Position
+	Term string
+}
 
 // Link refers to another page or a node with an ID `<a>`
 type Link struct {
+	Position
 	ID      string
 	Abbrev  string
 	Href    string
 	Caption string
 	Title   Paragraph
+	Tooltip string // `[text](href "Tooltip")`
 }
 
 // Image refers to an image `<img>`
 type Image struct {
-	Alt  Paragraph
-	Href string
+	Position
+	Alt     Paragraph
+	Href    string
+	Tooltip string // `![alt](href "Tooltip")`
 }
 
 func (InlineModifier) TagInline() {}
 
 // InlineModifier creates a span with the specified class `<span>`
 type InlineModifier struct {
+	Position
 	Class  string
 	Inline Inline
 }
@@ -0,0 +1,77 @@
+package mark
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// InlineSyntax describes custom inline syntax such as `@user` or `[[page]]`.
+type InlineSyntax struct {
+	// Triggers contains characters that may start the syntax.
+	Triggers string
+	// Parse parses the syntax at the start of text. It returns the node and
+	// the number of bytes consumed, size 0 means that text didn't match.
+	Parse func(ctx *InlineContext, text string) (node Inline, size int)
+}
+
+// InlineContext describes where the inline syntax is parsed
+type InlineContext struct {
+	FS   FileSystem
+	Path string // current file path relative to FS root
+	Line int
+
+	markup markup
+	text   string // text passed to Parse
+	at     int    // offset of text in the paragraph
+}
+
+// Abs returns the path relative to the FileSystem root
+// for a reference relative to the current file.
+func (ctx *InlineContext) Abs(ref string) string { return ctx.markup.reltoabs(ref) }
+
+// ParseInline parses text as nested inline markup.
+// Positions are correct when text is a part of text passed to Parse.
+func (ctx *InlineContext) ParseInline(text string) []Inline {
+	at := ctx.at
+	if i := strings.Index(ctx.text, text); i >= 0 {
+		at += i
+	}
+	markup := ctx.markup.parse.newMarkup([]string{text}, []int{ctx.markup.offset(at)})
+	return markup.resolve(markup.tokenize(text))
+}
+
+// Position returns the position of the first size bytes of text
+// passed to Parse, e.g. for the Position of the returned node.
+func (ctx *InlineContext) Position(size int) Position {
+	return ctx.markup.position(ctx.at, ctx.at+size)
+}
+
+// Error reports a problem at the start of text passed to Parse.
+func (ctx *InlineContext) Error(err error) { ctx.markup.diagnose(ctx.Position(1), err) }
+
+// parseCustomInline tries Parser.Inlines at the start of text
+func (markup markup) parseCustomInline(text string, at int) (Inline, int) {
+	if len(markup.book.Inlines) == 0 {
+		return nil, 0
+	}
+
+	r, _ := utf8.DecodeRuneInString(text)
+	for _, syntax := range markup.book.Inlines {
+		if syntax.Parse == nil || !strings.ContainsRune(syntax.Triggers, r) {
+			continue
+		}
+		ctx := &InlineContext{
+			FS:     markup.fs,
+			Path:   markup.path,
+			Line:   markup.reader.head.line,
+			markup: markup,
+			text:   text,
+			at:     at,
+		}
+		if node, size := syntax.Parse(ctx, text); size > 0 && node != nil {
+			setPosition(node, ctx.Position(size))
+			return node, size
+		}
+	}
+	return nil, 0
+}
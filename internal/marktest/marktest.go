@@ -0,0 +1,57 @@
+// Package marktest contains helpers for testing parsed documents.
+package marktest
+
+import (
+	"reflect"
+
+	"github.com/loov/mark"
+)
+
+// ClearPositions returns a copy of seq with all positions zeroed,
+// so that documents can be compared with reflect.DeepEqual.
+func ClearPositions(seq mark.Sequence) mark.Sequence {
+	return clearPositions(reflect.ValueOf(seq)).Interface().(mark.Sequence)
+}
+
+var positionType = reflect.TypeOf(mark.Position{})
+
+func clearPositions(v reflect.Value) reflect.Value {
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			return v
+		}
+		r := reflect.New(v.Type().Elem())
+		r.Elem().Set(clearPositions(v.Elem()))
+		return r
+	case reflect.Interface:
+		if v.IsNil() {
+			return v
+		}
+		r := reflect.New(v.Type()).Elem()
+		r.Set(clearPositions(v.Elem()))
+		return r
+	case reflect.Slice:
+		if v.IsNil() {
+			return v
+		}
+		r := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		for i := 0; i < v.Len(); i++ {
+			r.Index(i).Set(clearPositions(v.Index(i)))
+		}
+		return r
+	case reflect.Struct:
+		if v.Type() == positionType {
+			return reflect.Zero(positionType)
+		}
+		r := reflect.New(v.Type()).Elem()
+		r.Set(v)
+		for i := 0; i < v.NumField(); i++ {
+			if r.Field(i).CanSet() {
+				r.Field(i).Set(clearPositions(v.Field(i)))
+			}
+		}
+		return r
+	}
+	return v
+}
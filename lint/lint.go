@@ -0,0 +1,435 @@
+// Package lint checks parsed books for style problems that are not
+// parse errors, such as skipped heading levels or bare URLs.
+//
+// Findings are reported as *mark.ParseError, so they can be formatted
+// with mark.Pretty and serialized with package report.
+package lint
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/loov/mark"
+)
+
+// Codes of the lint rules
+const (
+	ErrHeadingSkip   mark.ErrorCode = "heading-skip"        // `#` followed by `###`
+	ErrMultipleH1    mark.ErrorCode = "multiple-h1"         // more than one `#` heading in a file
+	ErrImageAlt      mark.ErrorCode = "image-alt"           // image without alt text
+	ErrEmptyLink     mark.ErrorCode = "empty-link"          // link without text or destination
+	ErrBareURL       mark.ErrorCode = "bare-url"            // URL in text that is not a link
+	ErrTrailingSpace mark.ErrorCode = "trailing-whitespace" // spaces or tabs at the end of a line
+	ErrLongCodeLine  mark.ErrorCode = "long-code-line"      // code line longer than Config.MaxCodeLine
+	ErrListMarker    mark.ErrorCode = "list-marker"         // list marker differs from the first one in a file
+	ErrSetextHeading mark.ErrorCode = "setext-heading"      // heading underlined with `===` or `---`
+)
+
+// Rule describes a lint rule
+type Rule struct {
+	Code        mark.ErrorCode
+	Severity    mark.Severity // default severity
+	Description string
+}
+
+// Rules lists all lint rules
+var Rules = []Rule{
+	{ErrHeadingSkip, mark.SeverityWarning, "heading levels should only increase by one"},
+	{ErrMultipleH1, mark.SeverityWarning, "a file should have a single level 1 heading"},
+	{ErrImageAlt, mark.SeverityWarning, "images should have alt text"},
+	{ErrEmptyLink, mark.SeverityError, "links should have text and a destination"},
+	{ErrBareURL, mark.SeverityInfo, "URLs should be written as links"},
+	{ErrTrailingSpace, mark.SeverityInfo, "lines should not end with whitespace"},
+	{ErrLongCodeLine, mark.SeverityInfo, "code lines should fit on a page"},
+	{ErrListMarker, mark.SeverityInfo, "lists in a file should use the same marker"},
+	{ErrSetextHeading, mark.SeverityInfo, "headings should use `#`"},
+}
+
+// DefaultMaxCodeLine is the maximum width of code lines when Config.MaxCodeLine is 0
+const DefaultMaxCodeLine = 80
+
+// Config enables rules
+type Config struct {
+	// Rules contains enabled rules with the severity of their diagnostics.
+	Rules map[mark.ErrorCode]mark.Severity
+
+	// MaxCodeLine is the maximum number of characters in a code line,
+	// DefaultMaxCodeLine when 0.
+	MaxCodeLine int
+}
+
+// Default returns a config with all rules enabled with their default severity
+func Default() *Config {
+	config := &Config{Rules: map[mark.ErrorCode]mark.Severity{}}
+	for _, rule := range Rules {
+		config.Rules[rule.Code] = rule.Severity
+	}
+	return config
+}
+
+// Lint checks seq with the default config
+func Lint(fs mark.FileSystem, seq mark.Sequence) []error {
+	return Default().Lint(fs, seq)
+}
+
+// Lint checks seq and the source files it was parsed from. Files
+// are read from fs using the paths in node positions.
+func (config *Config) Lint(fs mark.FileSystem, seq mark.Sequence) []error {
+	linter := &linter{
+		Config: config,
+		fs:     fs,
+		files:  map[string]*file{},
+	}
+	mark.WalkPath(seq, linter.check)
+
+	sort.SliceStable(linter.errs, func(i, k int) bool {
+		a, b := linter.errs[i], linter.errs[k]
+		if a.Path != b.Path {
+			return linter.files[a.Path].index < linter.files[b.Path].index
+		}
+		if a.Line != b.Line {
+			return a.Line < b.Line
+		}
+		return a.Column < b.Column
+	})
+
+	errs := make([]error, 0, len(linter.errs))
+	for _, err := range linter.errs {
+		errs = append(errs, err)
+	}
+	return errs
+}
+
+type linter struct {
+	*Config
+	fs    mark.FileSystem
+	files map[string]*file
+	errs  []*mark.ParseError
+}
+
+// file is a source file with per-file rule state
+type file struct {
+	index  int // order of first appearance
+	source string
+	starts []int // offsets of lines
+
+	level  int  // level of the last heading
+	titles int  // number of level 1 headings
+	marker byte // first list marker
+}
+
+// file returns the source file at path, loading it on first use
+func (linter *linter) file(path string) *file {
+	if f, ok := linter.files[path]; ok {
+		return f
+	}
+	f := &file{index: len(linter.files)}
+	linter.files[path] = f
+	if path == "" || linter.fs == nil {
+		return f
+	}
+	data, err := linter.fs.ReadFile(path)
+	if err != nil {
+		return f
+	}
+	f.source = string(data)
+	f.starts = lineStarts(f.source)
+	linter.trailingSpace(path, f)
+	return f
+}
+
+// lineStarts returns offsets of lines, line endings are
+// handled the same way as by the parser.
+func lineStarts(source string) []int {
+	starts := []int{0}
+	for i := 0; i < len(source); i++ {
+		c := source[i]
+		if c != '\r' && c != '\n' {
+			continue
+		}
+		if i+1 < len(source) && (source[i+1] == '\r' || source[i+1] == '\n') && source[i+1] != c {
+			i++
+		}
+		starts = append(starts, i+1)
+	}
+	return starts
+}
+
+// line returns the text of 1-based line n
+func (f *file) line(n int) string {
+	if n < 1 || n > len(f.starts) {
+		return ""
+	}
+	line := f.source[f.starts[n-1]:]
+	if end := strings.IndexAny(line, "\r\n"); end >= 0 {
+		line = line[:end]
+	}
+	return line
+}
+
+// location returns the location of offset in source
+func (f *file) location(offset int) mark.Location {
+	line := sort.Search(len(f.starts), func(i int) bool { return f.starts[i] > offset }) - 1
+	if line < 0 {
+		line = 0
+	}
+	return mark.Location{
+		Line:   line + 1,
+		Column: offset - f.starts[line] + 1,
+		Offset: offset,
+	}
+}
+
+// report adds a diagnostic at pos when the rule is enabled,
+// the result is nil otherwise.
+func (linter *linter) report(code mark.ErrorCode, pos mark.Position, format string, args ...interface{}) *mark.ParseError {
+	severity, ok := linter.Rules[code]
+	if !ok {
+		return nil
+	}
+	diag := &mark.ParseError{
+		Path:     pos.Path,
+		Line:     pos.Start.Line,
+		Err:      code.Errorf(format, args...),
+		Column:   pos.Start.Column,
+		Severity: severity,
+		Code:     code,
+		Source:   linter.file(pos.Path).line(pos.Start.Line),
+	}
+	if pos.End.Line == pos.Start.Line {
+		diag.EndColumn = pos.End.Column
+	}
+	linter.errs = append(linter.errs, diag)
+	return diag
+}
+
+// fix sets edits of diag, which may be nil
+func fix(diag *mark.ParseError, edits ...mark.Edit) {
+	if diag != nil {
+		diag.Fix = edits
+	}
+}
+
+// check runs the rules for node
+func (linter *linter) check(node mark.Node, parents []mark.Node) error {
+	linter.file(node.Pos().Path)
+
+	switch node := node.(type) {
+	case *mark.Section:
+		linter.heading(node)
+	case *mark.Code:
+		linter.code(node)
+	case *mark.List:
+		linter.list(node)
+	case mark.Text:
+		if !insideLink(parents) {
+			linter.bareURL(node)
+		}
+	case mark.Link:
+		linter.link(node)
+	case mark.Image:
+		if !hasContent(node.Alt.Items) {
+			linter.report(ErrImageAlt, node.Position, "Image %s has no alt text", node.Href)
+		}
+	}
+	return nil
+}
+
+// insideLink checks whether parents contain a link, URLs are allowed as link text
+func insideLink(parents []mark.Node) bool {
+	for _, parent := range parents {
+		if _, ok := parent.(mark.Link); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// heading checks heading levels
+func (linter *linter) heading(sec *mark.Section) {
+	f := linter.file(sec.Path)
+	if f.level > 0 && sec.Level > f.level+1 {
+		linter.report(ErrHeadingSkip, sec.Position, "Heading level %d follows level %d", sec.Level, f.level)
+	}
+	f.level = sec.Level
+	linter.setext(f, sec)
+
+	if sec.Level == 1 {
+		f.titles++
+		if f.titles > 1 {
+			linter.report(ErrMultipleH1, sec.Position, "Multiple level 1 headings")
+		}
+	}
+}
+
+// setext checks for headings underlined with `===` or `---`
+func (linter *linter) setext(f *file, sec *mark.Section) {
+	if sec.End.Line != sec.Start.Line+1 || sec.Level > 2 {
+		return
+	}
+	title, underline := f.line(sec.Start.Line), strings.TrimSpace(f.line(sec.End.Line))
+	if underline == "" || strings.Trim(underline, "=-") != "" {
+		return
+	}
+
+	diag := linter.report(ErrSetextHeading, sec.Position, "Setext heading, use %s", strings.Repeat("#", sec.Level))
+	if strings.HasPrefix(strings.TrimSpace(title), ">") {
+		// nested in a quote
+		return
+	}
+	text := strings.TrimSpace(title)
+	if closing := strings.TrimRight(text, "#"); closing != text && (closing == "" || strings.HasSuffix(closing, " ")) {
+		// escape, so that trailing `#` are not a closing sequence
+		text = closing + "\\" + text[len(closing):]
+	}
+	start := f.starts[sec.Start.Line-1]
+	fix(diag, mark.Edit{
+		Path:  sec.Path,
+		Start: start,
+		End:   f.starts[sec.End.Line-1] + len(f.line(sec.End.Line)),
+		Text:  strings.Repeat("#", sec.Level) + " " + text,
+	})
+}
+
+// link checks for empty links
+func (linter *linter) link(link mark.Link) {
+	switch {
+	case link.ID == "" && (link.Href == "" || link.Href == "#"):
+		linter.report(ErrEmptyLink, link.Position, "Link has no destination")
+	case !hasContent(link.Title.Items):
+		linter.report(ErrEmptyLink, link.Position, "Link has no text")
+	}
+}
+
+// hasContent checks whether items contain something other than whitespace
+func hasContent(items []mark.Inline) bool {
+	for _, item := range items {
+		switch item := item.(type) {
+		case mark.Text:
+			if strings.TrimSpace(item.Value) != "" {
+				return true
+			}
+		case mark.SoftBreak, mark.HardBreak:
+		default:
+			return true
+		}
+	}
+	return false
+}
+
+var rxURL = regexp.MustCompile(`(?:https?|ftp)://[^\s<>]+`)
+
+// bareURL checks for URLs in text
+func (linter *linter) bareURL(text mark.Text) {
+	f := linter.file(text.Path)
+	start, end := text.Start.Offset, text.End.Offset
+	located := text.IsValid() && 0 <= start && start <= end && end <= len(f.source)
+	for _, url := range rxURL.FindAllString(text.Value, -1) {
+		url = strings.TrimRight(url, ".,:;!?)'\"")
+
+		pos := text.Position
+		if located {
+			if i := strings.Index(f.source[start:end], url); i >= 0 {
+				pos.Start = f.location(start + i)
+				pos.End = f.location(start + i + len(url))
+				start += i + len(url)
+			}
+		}
+		linter.report(ErrBareURL, pos, "Bare URL %s, use [text](%s)", url, url)
+	}
+}
+
+// trailingSpace checks all lines of f
+func (linter *linter) trailingSpace(path string, f *file) {
+	for n := 1; n <= len(f.starts); n++ {
+		line := f.line(n)
+		trimmed := strings.TrimRight(line, " \t")
+		if len(trimmed) == len(line) {
+			continue
+		}
+		at := f.starts[n-1]
+		diag := linter.report(ErrTrailingSpace, mark.Position{
+			Path:  path,
+			Start: f.location(at + len(trimmed)),
+			End:   f.location(at + len(line)),
+		}, "Trailing whitespace")
+		fix(diag, mark.Edit{Path: path, Start: at + len(trimmed), End: at + len(line)})
+	}
+}
+
+// code checks the width of code lines
+func (linter *linter) code(code *mark.Code) {
+	max := linter.MaxCodeLine
+	if max <= 0 {
+		max = DefaultMaxCodeLine
+	}
+
+	f := linter.file(code.Path)
+	first := code.Start.Line
+	if fence := strings.TrimSpace(f.line(first)); strings.Contains(fence, "```") || strings.Contains(fence, "~~~") {
+		first++
+	}
+
+	for i, text := range code.Lines {
+		width := utf8.RuneCountInString(text)
+		if width <= max {
+			continue
+		}
+
+		pos := code.Position
+		n := first + i
+		if k := strings.Index(f.line(n), text); k >= 0 {
+			at := f.starts[n-1] + k
+			pos.Start = f.location(at + runeOffset(text, max))
+			pos.End = f.location(at + len(text))
+		}
+		linter.report(ErrLongCodeLine, pos, "Code line is %d characters long, maximum is %d", width, max)
+	}
+}
+
+// runeOffset returns the byte offset of rune n in s
+func runeOffset(s string, n int) int {
+	for i := range s {
+		if n == 0 {
+			return i
+		}
+		n--
+	}
+	return len(s)
+}
+
+// list checks that unordered lists use the same marker
+func (linter *linter) list(list *mark.List) {
+	if list.Ordered {
+		return
+	}
+	for _, item := range list.Content {
+		pos := item.Pos()
+		if !pos.IsValid() {
+			pos = list.Position
+		}
+		f := linter.file(pos.Path)
+		line := f.line(pos.Start.Line)
+		i := strings.IndexFunc(line, func(r rune) bool { return r != ' ' && r != '\t' && r != '>' })
+		if i < 0 || !strings.ContainsRune("*-+", rune(line[i])) {
+			continue
+		}
+
+		marker := line[i]
+		if f.marker == 0 {
+			f.marker = marker
+			continue
+		}
+		if marker != f.marker {
+			at := f.starts[pos.Start.Line-1] + i
+			diag := linter.report(ErrListMarker, mark.Position{
+				Path:  pos.Path,
+				Start: f.location(at),
+				End:   f.location(at + 1),
+			}, "List marker %q differs from %q", marker, f.marker)
+			fix(diag, mark.Edit{Path: pos.Path, Start: at, End: at + 1, Text: string(f.marker)})
+		}
+	}
+}
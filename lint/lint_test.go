@@ -0,0 +1,117 @@
+package lint_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/loov/mark"
+	"github.com/loov/mark/lint"
+)
+
+type Case struct {
+	In  string
+	Exp []string // `line:column code`
+}
+
+func run(t *testing.T, config *lint.Config, cases []Case) {
+	t.Helper()
+	for i, test := range cases {
+		fs := mark.VirtualDir{"main.md": test.In, "a.md": ""}
+		seq, _ := mark.ParseFile(fs, "main.md")
+
+		got := []string{}
+		for _, err := range config.Lint(fs, seq) {
+			diag, ok := err.(*mark.ParseError)
+			if !ok {
+				t.Fatalf("#%d: expected ParseError, got %T", i, err)
+			}
+			got = append(got, fmt.Sprintf("%d:%d %s", diag.Line, diag.Column, diag.Code))
+		}
+		if test.Exp == nil {
+			test.Exp = []string{}
+		}
+		if strings.Join(got, "\n") != strings.Join(test.Exp, "\n") {
+			t.Errorf("#%d: %q\ngot  %q\nexp  %q", i, test.In, got, test.Exp)
+		}
+	}
+}
+
+func TestRules(t *testing.T) {
+	run(t, lint.Default(), []Case{{
+		In:  "# Title\n\n## Sub\n\n### Subsub\n\n# Other\n\n### Skip",
+		Exp: []string{"7:1 multiple-h1", "9:1 heading-skip"},
+	}, {
+		In:  "## Start\n\n#### Skip",
+		Exp: []string{"3:1 heading-skip"},
+	}, {
+		In:  "![](a.md) ![alt](a.md) ![ ](a.md)",
+		Exp: []string{"1:1 image-alt", "1:24 image-alt"},
+	}, {
+		In:  "[x]() [](a.md) [x](#) [x](a.md) [x](#title)",
+		Exp: []string{"1:1 empty-link", "1:7 empty-link", "1:16 empty-link"},
+	}, {
+		In:  "See https://example.com/x. or [https://a](https://a), https://example.com/x",
+		Exp: []string{"1:5 bare-url", "1:55 bare-url"},
+	}, {
+		In:  "Text  \nmore\t\nnone",
+		Exp: []string{"1:5 trailing-whitespace", "2:5 trailing-whitespace"},
+	}, {
+		In:  "```\n" + strings.Repeat("x", 80) + "\n" + strings.Repeat("y", 85) + "\n```\n\n    " + strings.Repeat("z", 81),
+		Exp: []string{"3:81 long-code-line", "6:85 long-code-line"},
+	}, {
+		In:  "* a\n* b\n\n- c\n\n* d",
+		Exp: []string{"4:1 list-marker"},
+	}, {
+		In:  "Nothing *to* see [here](a.md).",
+		Exp: nil,
+	}})
+}
+
+func TestConfig(t *testing.T) {
+	config := &lint.Config{
+		Rules: map[mark.ErrorCode]mark.Severity{
+			lint.ErrLongCodeLine: mark.SeverityError,
+		},
+		MaxCodeLine: 4,
+	}
+	run(t, config, []Case{{
+		In:  "Text  \n\n```\nabcd\nabcdé\n```",
+		Exp: []string{"5:5 long-code-line"},
+	}})
+
+	fs := mark.VirtualDir{"main.md": "```\nabcde\n```"}
+	seq, _ := mark.ParseFile(fs, "main.md")
+	errs := config.Lint(fs, seq)
+	if len(errs) != 1 {
+		t.Fatalf("expected a single error, got %v", errs)
+	}
+	diag := errs[0].(*mark.ParseError)
+	if diag.Severity != mark.SeverityError {
+		t.Errorf("expected error severity, got %v", diag.Severity)
+	}
+	exp := "main.md:2:5: error: Code line is 5 characters long, maximum is 4 [long-code-line]\n\tabcde\n\t    ^"
+	if got := mark.Pretty(diag); got != exp {
+		t.Errorf("got\n%s\nexp\n%s", got, exp)
+	}
+}
+
+func TestIncludes(t *testing.T) {
+	fs := mark.VirtualDir{
+		"book.md": "# Book\n\n{{include ch1.md}}",
+		"ch1.md":  "# Chapter\n\n### Skip ",
+	}
+	seq, _ := mark.ParseFile(fs, "book.md")
+
+	got := []string{}
+	for _, err := range lint.Lint(fs, seq) {
+		got = append(got, err.Error())
+	}
+	exp := []string{
+		"ch1.md:3: Heading level 3 follows level 1",
+		"ch1.md:3: Trailing whitespace",
+	}
+	if strings.Join(got, "\n") != strings.Join(exp, "\n") {
+		t.Errorf("got\n%s\nexp\n%s", strings.Join(got, "\n"), strings.Join(exp, "\n"))
+	}
+}
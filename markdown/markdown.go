@@ -0,0 +1,850 @@
+// Package markdown renders a parsed Sequence back into markdown.
+//
+// Text is escaped so that parsing the result with mark.ParseContent
+// produces an equal Sequence, ignoring positions. Nodes that the parser
+// doesn't produce, such as HardBreak or Modifier with several blocks,
+// are written using the closest syntax. Index terms have no markdown
+// syntax and are dropped.
+package markdown
+
+import (
+	"fmt"
+	"path"
+	"reflect"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/loov/mark"
+)
+
+var inlineRenderers = map[reflect.Type]func(mark.Inline) string{}
+
+// RegisterInline registers a renderer for custom inline nodes
+// of the same type as node.
+func RegisterInline(node mark.Inline, render func(mark.Inline) string) {
+	inlineRenderers[reflect.TypeOf(node)] = render
+}
+
+// Convert renders seq as markdown
+func Convert(seq mark.Sequence) string {
+	return ConvertFile("", seq)
+}
+
+// ConvertFile renders seq as the content of the file at filename, which is
+// relative to the FileSystem root. Links are written relative to filename
+// and blocks included from other files are replaced with the directive
+// lines that included them, such as `{{chapter.md level=+1}}`.
+func ConvertFile(filename string, seq mark.Sequence) string {
+	w := &writer{path: filename}
+	w.blocks(seq)
+	return w.String()
+}
+
+type writer struct {
+	strings.Builder
+	path    string   // file being written, "" when unknown
+	choices *choices // alternatives of the paragraph being written
+	runs    runs     // delimiter runs around the items being written
+}
+
+// runs are the delimiter runs of emphasis next to an item
+type runs struct {
+	delim       rune // delimiter of the enclosing emphasis
+	open, close int  // number of adjacent opening and closing runs
+}
+
+// blocks writes blocks separated by empty lines
+func (w *writer) blocks(seq mark.Sequence) {
+	var included *mark.Invocation
+	for _, block := range w.flatten(seq) {
+		if by := w.includedBy(block); by != nil {
+			if by != included {
+				w.separate()
+				w.WriteString(by.Text + "\n")
+			}
+			included = by
+			w.blocks(w.ownBlocks(block, by))
+			continue
+		}
+		included = nil
+		w.separate()
+		w.block(block)
+	}
+}
+
+// flatten inlines nested sequences
+func (w *writer) flatten(seq mark.Sequence) (blocks []mark.Block) {
+	for _, block := range seq {
+		switch block := block.(type) {
+		case *mark.Sequence:
+			blocks = append(blocks, w.flatten(*block)...)
+		case mark.Sequence:
+			blocks = append(blocks, w.flatten(block)...)
+		default:
+			blocks = append(blocks, block)
+		}
+	}
+	return blocks
+}
+
+// includedBy returns the directive line of the current file that included
+// block, blocks from other files without one are written inline.
+func (w *writer) includedBy(block mark.Block) *mark.Invocation {
+	by := block.Pos().IncludedBy
+	if by == nil || by.Path != w.path {
+		return nil
+	}
+	return by
+}
+
+// ownBlocks returns the outermost blocks inside an included section that
+// don't come from the include, they follow the include in the source.
+func (w *writer) ownBlocks(block mark.Block, by *mark.Invocation) (own mark.Sequence) {
+	mark.WalkPath(block, func(node mark.Node, parents []mark.Node) error {
+		if len(parents) == 0 {
+			return nil
+		}
+		block, ok := node.(mark.Block)
+		if !ok {
+			return mark.SkipChildren
+		}
+		if other := w.includedBy(block); node.Pos().Path == w.path || other != nil && other != by {
+			own = append(own, block)
+			return mark.SkipChildren
+		}
+		return nil
+	})
+	return own
+}
+
+// separate starts a new block after an empty line
+func (w *writer) separate() {
+	if w.Len() > 0 {
+		w.WriteString("\n")
+	}
+}
+
+func (w *writer) block(block mark.Block) {
+	switch block := block.(type) {
+	case *mark.Paragraph:
+		w.WriteString(w.paragraph(block) + "\n")
+	case *mark.Section:
+		w.WriteString(strings.Repeat("#", block.Level) + " " + headingTitle(w.paragraph(&block.Title)))
+		if block.ID != "" {
+			w.WriteString(" {#" + block.ID + "}")
+		}
+		w.WriteString("\n")
+		w.blocks(block.Content)
+	case *mark.Quote:
+		w.nested(block.Content, "> ", "> ")
+	case *mark.Modifier:
+		w.WriteString("{." + block.Class + "}\n")
+		// the modifier applies to the next paragraph
+		for i, content := range w.flatten(block.Content) {
+			if i > 0 {
+				w.WriteString("\n")
+			}
+			w.block(content)
+		}
+	case *mark.Code:
+		w.code(block)
+	case *mark.List:
+		if len(block.Content) == 0 {
+			w.WriteString("* \n")
+		}
+		for i, item := range block.Content {
+			marker := "* "
+			if block.Ordered {
+				marker = fmt.Sprintf("%d. ", i+1)
+			}
+			if i > 0 {
+				// items are separated by an empty item
+				w.WriteString("*\n")
+			}
+			// TODO: use indentation once the list parser supports it
+			w.nested(item, marker, "* ")
+		}
+	case *mark.Separator:
+		w.WriteString("*** ")
+		if !block.Title.IsEmpty() {
+			title := w.paragraph(&block.Title)
+			if strings.HasSuffix(title, "*") {
+				// trailing delimiters are trimmed
+				title += "\\"
+			}
+			w.WriteString(keepSpaces(title))
+		}
+		w.WriteString("\n")
+	default:
+		panic(fmt.Errorf("unimplemented: %#+v", block))
+	}
+}
+
+// headingTitle protects the end of a rendered title from being trimmed
+func headingTitle(title string) string {
+	if closing := strings.TrimRight(title, "#"); closing != title && strings.HasSuffix(closing, " ") {
+		// closing sequence
+		return closing + "\\" + title[len(closing):]
+	}
+	return keepSpaces(title)
+}
+
+// keepSpaces protects trailing spaces of a trimmed line
+func keepSpaces(line string) string {
+	if strings.HasSuffix(line, " ") {
+		// a backslash at the end of a line is dropped
+		return line + "\\"
+	}
+	return line
+}
+
+// nested writes seq with each line prefixed,
+// the first line uses first and others use rest.
+func (w *writer) nested(seq mark.Sequence, first, rest string) {
+	inner := &writer{path: w.path}
+	inner.blocks(seq)
+	lines := strings.Split(strings.TrimSuffix(inner.String(), "\n"), "\n")
+	for i, line := range lines {
+		prefix := rest
+		if i == 0 {
+			prefix = first
+		}
+		if line == "" {
+			prefix = strings.TrimRight(prefix, " ")
+		}
+		w.WriteString(prefix + line + "\n")
+	}
+}
+
+// code writes a fenced code block with a fence longer than any fence in the content
+func (w *writer) code(code *mark.Code) {
+	info := code.Info
+	if info == "" {
+		info = code.Language
+	}
+
+	fence := "`"
+	if strings.Contains(info, "`") {
+		fence = "~"
+	}
+	size := 3
+	for _, line := range code.Lines {
+		trimmed := strings.TrimLeft(line, " ")
+		n := len(trimmed) - len(strings.TrimLeft(trimmed, fence))
+		if n >= size {
+			size = n + 1
+		}
+	}
+	fence = strings.Repeat(fence, size)
+
+	w.WriteString(fence + info + "\n")
+	for _, line := range code.Lines {
+		w.WriteString(line + "\n")
+	}
+	w.WriteString(fence + "\n")
+}
+
+// maxSearch limits the alternatives that are tried for a paragraph,
+// as the number of bytes written, with 64 added for each attempt
+const maxSearch = 1 << 20
+
+// paragraph renders inlines of p with line starts escaped.
+//
+// Delimiter runs of adjacent emphasis and literal `*` or `_` can be
+// grouped by the parser in ways that are hard to predict, so paragraphs
+// with emphasis are parsed back and the alternative delimiters and
+// escapes are tried until the result is the same.
+func (w *writer) paragraph(p *mark.Paragraph) string {
+	if !hasEmphasis(p.Items) {
+		return w.lines(p.Items)
+	}
+
+	c := &choices{}
+	w.choices = c
+	defer func() { w.choices = nil }()
+
+	exp := signature(p.Items)
+	attempt := func() (string, int) {
+		c.options = c.options[:0]
+		text := w.lines(p.Items)
+		return text, w.similarity(text, exp)
+	}
+
+	first, best := attempt()
+	if best > len(exp) {
+		return first
+	}
+	c.picked = make([]int, len(c.options))
+
+	// keep the changes that make the start of the paragraph parse the same
+	for improved := true; improved; {
+		improved = false
+		for i := range c.picked {
+			keep := c.picked[i]
+			for option := 0; option < c.options[i]; option++ {
+				if option == keep {
+					continue
+				}
+				c.picked[i] = option
+				text, score := attempt()
+				if score > len(exp) {
+					return text
+				}
+				if score > best {
+					best, keep, improved = score, option, true
+				}
+			}
+			c.picked[i] = keep
+		}
+	}
+
+	// try the alternatives that change fewer choices first,
+	// delimiters alone get half of the attempts
+	limit := maxSearch / (len(first) + 64)
+	found, matched, budget := "", false, 0
+	try := func() bool {
+		budget--
+		text, score := attempt()
+		found, matched = text, score > len(exp)
+		return matched || budget <= 0
+	}
+	for _, escapes := range []bool{false, true} {
+		for i := range c.picked {
+			c.picked[i] = 0
+		}
+		budget += limit / 2
+		for changes := 1; changes <= len(c.picked) && budget > 0; changes++ {
+			if c.vary(0, changes, escapes, try) && matched {
+				return found
+			}
+		}
+	}
+	return first
+}
+
+// lines renders items with empty lines escaped
+func (w *writer) lines(items []mark.Inline) string {
+	lines := strings.Split(w.inlines(items, ' ', ' ', true), "\n")
+	for i, line := range lines {
+		if line == "" {
+			// an empty line would end the paragraph, a single backslash is dropped
+			lines[i] = "\\"
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// similarity returns the length of the common prefix of the signatures of
+// the paragraph parsed from text and exp, or len(exp)+1 when they are equal
+func (w *writer) similarity(text, exp string) int {
+	seq, _ := mark.ParseContent(nil, w.path, []byte(text))
+	if len(seq) != 1 {
+		return 0
+	}
+	p, ok := seq[0].(*mark.Paragraph)
+	if !ok {
+		return 0
+	}
+	got := signature(p.Items)
+	if got == exp {
+		return len(exp) + 1
+	}
+	n := 0
+	for n < len(got) && n < len(exp) && got[n] == exp[n] {
+		n++
+	}
+	return n
+}
+
+// choices are the alternative ways to write a paragraph, the number
+// and order of the points where they are picked is always the same
+type choices struct {
+	picked  []int  // option picked at each point
+	options []int  // number of options at each point
+	escapes []bool // whether the point picks escapes instead of a delimiter
+}
+
+// choose returns the option to use out of options, the first option
+// is the default and the only one used outside of paragraph.
+func (w *writer) choose(options int, escapes bool) int {
+	c := w.choices
+	if c == nil {
+		return 0
+	}
+	i := len(c.options)
+	c.options = append(c.options, options)
+	if i >= len(c.escapes) {
+		c.escapes = append(c.escapes, escapes)
+	}
+	if i < len(c.picked) {
+		return c.picked[i]
+	}
+	return 0
+}
+
+// vary picks other than the first option at changes points starting from
+// from, escapes is whether escape points are included, and calls try for
+// each combination until it returns true
+func (c *choices) vary(from, changes int, escapes bool, try func() bool) bool {
+	if changes == 0 {
+		return try()
+	}
+	for i := from; i < len(c.picked); i++ {
+		if c.escapes[i] && !escapes {
+			continue
+		}
+		for option := 1; option < c.options[i]; option++ {
+			c.picked[i] = option
+			if c.vary(i+1, changes-1, escapes, try) {
+				return true
+			}
+		}
+		c.picked[i] = 0
+	}
+	return false
+}
+
+// unescape picks how many `*` and `_` of the runs at the start and end of
+// text are left unescaped. Runs are matched by their length modulo three,
+// so only up to three and all of them are tried, an escape next to a run
+// changes whether it can open or close.
+func (w *writer) unescape(text string) (keepStart, keepEnd int) {
+	start := len(text) - len(strings.TrimLeft(text, "*_"))
+	end := len(text) - len(strings.TrimRight(text, "*_"))
+	if start == 0 && end == 0 {
+		return 0, 0
+	}
+	if start < len(text) {
+		keep := unescapeCounts(start)
+		keepStart = keep[w.choose(len(keep), true)]
+		keep = unescapeCounts(end)
+		keepEnd = keep[w.choose(len(keep), true)]
+		return keepStart, keepEnd
+	}
+
+	// the whole text is a run, the splits that leave it partly escaped
+	// are picked together
+	var splits [][2]int
+	for _, keepStart := range unescapeCounts(start) {
+		for _, keepEnd := range unescapeCounts(end) {
+			if keepStart+keepEnd < len(text) {
+				splits = append(splits, [2]int{keepStart, keepEnd})
+			}
+		}
+	}
+	splits = append(splits, [2]int{len(text), 0})
+	split := splits[w.choose(len(splits), true)]
+	return split[0], split[1]
+}
+
+// unescapeCounts returns the number of unescaped characters tried for a run
+func unescapeCounts(run int) []int {
+	counts := []int{0}
+	for n := 1; n <= run && n <= 3; n++ {
+		counts = append(counts, n)
+	}
+	if run > 3 {
+		counts = append(counts, run)
+	}
+	return counts
+}
+
+// inlines renders items, before and after are the characters
+// surrounding items and lineStart whether items start a line.
+func (w *writer) inlines(items []mark.Inline, before, after rune, lineStart bool) string {
+	outer := w.runs
+	w.runs = runs{}
+
+	var r strings.Builder
+	for i, item := range items {
+		prev := before
+		if r.Len() > 0 {
+			prev, _ = utf8.DecodeLastRuneInString(r.String())
+		}
+		next := after
+		if i+1 < len(items) {
+			next = firstRune(items[i+1])
+		}
+
+		switch item := item.(type) {
+		case mark.Text:
+			r.WriteString(escapeText(item.Value, lineStart, w.unescape))
+		case mark.Emphasis:
+			r.WriteString(w.emphasis(item.Items, 1, prev, next, outer.around(i, len(items))))
+		case mark.Bold:
+			r.WriteString(w.emphasis(item.Items, 2, prev, next, outer.around(i, len(items))))
+		case mark.CodeSpan:
+			r.WriteString(codeSpan(item.Value))
+		case mark.SoftBreak:
+			if i == 0 {
+				// keeps an opening delimiter before it left-flanking,
+				// the parser drops the backslash
+				r.WriteString("\\")
+			}
+			r.WriteString("\n")
+		case mark.HardBreak:
+			r.WriteString("\\\n")
+		case mark.Link:
+			r.WriteString("[" + w.inlines(item.Title.Items, '[', ']', false) + "]" + w.linkTail(item.Href, item.Tooltip))
+		case mark.Image:
+			r.WriteString("![" + w.inlines(item.Alt.Items, '[', ']', false) + "]" + w.linkTail(item.Href, item.Tooltip))
+		case mark.InlineModifier:
+			r.WriteString(w.inlines([]mark.Inline{item.Inline}, prev, next, lineStart))
+		case mark.Callout:
+			r.WriteString(escapeText(item.Value, lineStart, nil))
+		case mark.Index:
+			// no syntax, see package documentation
+		case mark.Ref:
+			caption := item.Abbrev
+			if caption == "" {
+				caption = item.ID
+			}
+			r.WriteString("[" + escapeText(caption, false, nil) + "](#" + item.ID + ")")
+		default:
+			render, ok := inlineRenderers[reflect.TypeOf(item)]
+			if !ok {
+				panic(fmt.Errorf("unimplemented: %#+v", item))
+			}
+			r.WriteString(render(item))
+		}
+
+		_, lineStart = item.(mark.SoftBreak)
+		if _, ok := item.(mark.HardBreak); ok {
+			lineStart = true
+		}
+	}
+	return r.String()
+}
+
+// emphasis renders Emphasis or Bold with size delimiters. Underscores
+// are used next to asterisks, so that sibling delimiter runs don't merge.
+// Runs of nested emphasis are split by the parser the same way.
+func (w *writer) emphasis(items []mark.Inline, size int, prev, next rune, adjacent runs) string {
+	// Bold directly inside continues the delimiter run, the parser pairs
+	// `***x***` into Emphasis of Bold and `****x****` into Bold of Bold
+	for len(items) == 1 {
+		bold, ok := items[0].(mark.Bold)
+		if !ok {
+			break
+		}
+		size, items = size+2, bold.Items
+	}
+
+	// `**x**` would be Bold instead of nested Emphasis and
+	// `*a *b* c*` would be split into two Emphasis
+	nested := false
+	for _, item := range items {
+		nested = nested || isEmphasis(item)
+	}
+
+	delim := '*'
+	if prev == '*' || next == '*' || nested {
+		if prev != '_' && next != '_' && !isWord(prev) && !isWord(next) {
+			delim = '_'
+		}
+	}
+	if adjacent.open >= 2 || adjacent.close >= 2 {
+		// a third run between two others could close the first one,
+		// so it continues the run next to it instead
+		if adjacent.delim == '*' || !isWord(prev) && !isWord(next) {
+			delim = adjacent.delim
+		}
+	}
+	if w.choose(2, false) == 1 {
+		// see writer.paragraph
+		delim = '*' + '_' - delim
+	}
+	d := strings.Repeat(string(delim), size)
+
+	around := delim
+	if delim == '*' && (isWord(prev) || isWord(next)) {
+		// `_` inside would prevent intraword `*` from opening or closing
+		around = ' '
+	}
+	w.runs = runs{delim: delim, open: adjacent.open, close: adjacent.close}
+	return d + w.inlines(items, around, around, false) + d
+}
+
+// around returns the runs next to the item at i of n items inside the
+// emphasis with outer runs. An only item is left out, as continuing
+// the runs on both sides would merge it with the enclosing emphasis.
+func (outer runs) around(i, n int) runs {
+	adjacent := runs{delim: outer.delim}
+	if outer.delim == 0 || n == 1 {
+		return adjacent
+	}
+	if i == 0 {
+		adjacent.open = outer.open + 1
+	}
+	if i == n-1 {
+		adjacent.close = outer.close + 1
+	}
+	return adjacent
+}
+
+// firstRune returns the first character of the rendered item
+func firstRune(item mark.Inline) rune {
+	switch item := item.(type) {
+	case mark.Text:
+		r, _ := utf8.DecodeRuneInString(item.Value)
+		if needsEscape(r) || r == '_' {
+			return '\\'
+		}
+		return r
+	case mark.Emphasis, mark.Bold:
+		return '*'
+	case mark.CodeSpan:
+		return '`'
+	case mark.SoftBreak, mark.HardBreak:
+		return '\n'
+	case mark.Image:
+		return '!'
+	case mark.InlineModifier:
+		return firstRune(item.Inline)
+	}
+	return '['
+}
+
+func isEmphasis(item mark.Inline) bool {
+	switch item.(type) {
+	case mark.Emphasis, mark.Bold:
+		return true
+	}
+	return false
+}
+
+// isWord checks whether r is neither space nor punctuation,
+// delimiter runs between such characters are intraword
+func isWord(r rune) bool {
+	return !unicode.IsSpace(r) && !unicode.IsPunct(r) && !unicode.IsSymbol(r)
+}
+
+// needsEscape checks whether r must always be escaped in text
+func needsEscape(r rune) bool { return strings.ContainsRune("\\*`[]{}", r) }
+
+// escapeText escapes markup in text, lineStart escapes block syntax
+// at the beginning of a line. When unescape isn't nil, it picks how many
+// `*` and `_` of the runs at the start and end of text are left unescaped.
+func escapeText(text string, lineStart bool, unescape func(text string) (keepStart, keepEnd int)) string {
+	keepStart, keepEnd := 0, 0
+	if unescape != nil {
+		keepStart, keepEnd = unescape(text)
+	}
+
+	var r strings.Builder
+	for i, c := range text {
+		escaped := false
+		switch {
+		case needsEscape(c):
+			escaped = true
+		case c == '_':
+			prev, _ := utf8.DecodeLastRuneInString(text[:i])
+			next, _ := utf8.DecodeRuneInString(text[i+1:])
+			escaped = i == 0 || i+1 == len(text) || !isWord(prev) || !isWord(next)
+		case c == '!' && i+1 == len(text):
+			// would start an image before a link
+			escaped = true
+		case i == 0 && lineStart && strings.ContainsRune("#>-+=~<|", c):
+			escaped = true
+		case lineStart && (c == '.' || c == ')') && i > 0 && isDigits(text[:i]):
+			// numbered list
+			escaped = true
+		}
+		if i < keepStart || i >= len(text)-keepEnd {
+			escaped = false
+		}
+		if escaped {
+			r.WriteByte('\\')
+		}
+		// invalid UTF-8 is kept as is
+		_, size := utf8.DecodeRuneInString(text[i:])
+		r.WriteString(text[i : i+size])
+	}
+	return r.String()
+}
+
+// hasEmphasis checks whether items contain Emphasis or Bold
+func hasEmphasis(items []mark.Inline) bool {
+	for _, item := range items {
+		switch item := item.(type) {
+		case mark.Emphasis, mark.Bold:
+			return true
+		case mark.Link:
+			if hasEmphasis(item.Title.Items) {
+				return true
+			}
+		case mark.Image:
+			if hasEmphasis(item.Alt.Items) {
+				return true
+			}
+		case mark.InlineModifier:
+			if hasEmphasis([]mark.Inline{item.Inline}) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// signature describes the text and emphasis of items, ignoring the
+// details that writer.paragraph doesn't try to vary
+func signature(items []mark.Inline) string {
+	var r strings.Builder
+	var walk func(items []mark.Inline)
+	walk = func(items []mark.Inline) {
+		for _, item := range items {
+			switch item := item.(type) {
+			case mark.Text:
+				r.WriteString(item.Value)
+			case mark.Callout:
+				r.WriteString(item.Value)
+			case mark.SoftBreak:
+				r.WriteString("\n")
+			case mark.Emphasis:
+				r.WriteString("\x00*")
+				walk(item.Items)
+				r.WriteString("\x00/")
+			case mark.Bold:
+				r.WriteString("\x00**")
+				walk(item.Items)
+				r.WriteString("\x00/")
+			case mark.Link:
+				r.WriteString("\x00[")
+				walk(item.Title.Items)
+				r.WriteString("\x00]")
+			case mark.Image:
+				r.WriteString("\x00![")
+				walk(item.Alt.Items)
+				r.WriteString("\x00]")
+			case mark.Ref:
+				// written as a link with the caption as title
+				caption := item.Abbrev
+				if caption == "" {
+					caption = item.ID
+				}
+				r.WriteString("\x00[" + caption + "\x00]")
+			case mark.InlineModifier:
+				walk([]mark.Inline{item.Inline})
+			case mark.Index:
+			default:
+				r.WriteString("\x00?")
+			}
+		}
+	}
+	walk(items)
+	return r.String()
+}
+
+func isDigits(s string) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return s != ""
+}
+
+// codeSpan renders value with backticks that don't occur in value
+func codeSpan(value string) string {
+	longest, run := 0, 0
+	for _, c := range value {
+		if c == '`' {
+			run++
+			if run > longest {
+				longest = run
+			}
+		} else {
+			run = 0
+		}
+	}
+	ticks := strings.Repeat("`", longest+1)
+
+	pad := strings.HasPrefix(value, "`") || strings.HasSuffix(value, "`") ||
+		strings.HasPrefix(value, " ") && strings.HasSuffix(value, " ") && strings.Trim(value, " ") != ""
+	if pad {
+		return ticks + " " + value + " " + ticks
+	}
+	return ticks + value + ticks
+}
+
+// linkTail renders `(href "tooltip")`
+func (w *writer) linkTail(href, tooltip string) string {
+	if w.path != "" && isLocal(href) {
+		href = relative(path.Dir(w.path), href)
+	}
+
+	var r strings.Builder
+	r.WriteString("(")
+	if href == "" || strings.IndexFunc(href, isControlOrSpace) >= 0 {
+		r.WriteString("<" + escape(href, `\<>`) + ">")
+	} else {
+		r.WriteString(escape(href, `\()<`))
+	}
+	if tooltip != "" {
+		r.WriteString(` "` + escape(tooltip, `\"`) + `"`)
+	}
+	r.WriteString(")")
+	return r.String()
+}
+
+// isControlOrSpace checks whether r ends a destination without `<>`
+func isControlOrSpace(r rune) bool { return r <= ' ' || r == 0x7f }
+
+// escape adds backslashes before chars in s
+func escape(s, chars string) string {
+	var r strings.Builder
+	for i, c := range s {
+		if strings.ContainsRune(chars, c) {
+			r.WriteByte('\\')
+		}
+		_, size := utf8.DecodeRuneInString(s[i:])
+		r.WriteString(s[i : i+size])
+	}
+	return r.String()
+}
+
+// isLocal checks whether href is a relative path resolved by the parser
+func isLocal(href string) bool {
+	if href == "" || strings.HasPrefix(href, "/") || strings.HasPrefix(href, "#") {
+		return false
+	}
+	for i, c := range href {
+		switch {
+		case c == ':' && i > 0:
+			return false
+		case 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z':
+		case '0' <= c && c <= '9' || c == '+' || c == '-' || c == '.':
+			if i == 0 {
+				return true
+			}
+		default:
+			return true
+		}
+	}
+	return true
+}
+
+// relative returns target relative to dir, both are relative to
+// the FileSystem root.
+func relative(dir, target string) string {
+	split := func(p string) []string {
+		if p == "." || p == "" {
+			return nil
+		}
+		return strings.Split(p, "/")
+	}
+	from, to := split(dir), split(target)
+	for len(from) > 0 && len(to) > 0 && from[0] == to[0] && to[0] != ".." {
+		from, to = from[1:], to[1:]
+	}
+	parts := make([]string, 0, len(from)+len(to))
+	for range from {
+		parts = append(parts, "..")
+	}
+	parts = append(parts, to...)
+	if len(parts) == 0 {
+		return "."
+	}
+	return strings.Join(parts, "/")
+}
@@ -0,0 +1,222 @@
+package markdown_test
+
+import (
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/loov/mark"
+	"github.com/loov/mark/internal/marktest"
+	"github.com/loov/mark/markdown"
+)
+
+func TestConvert(t *testing.T) {
+	tests := []struct {
+		In  string
+		Exp string
+	}{
+		{"# Title {#intro}\n\nPara *em* **bold**", "# Title {#intro}\n\nPara *em* **bold**\n"},
+		{"Setext\n===", "# Setext\n"},
+		{"A `x` ``a`b`` `` `x` ``", "A `x` ``a`b`` `` `x` ``\n"},
+		{"[a *b*](x.md \"say \\\"hi\\\"\") ![alt](<y z.png>)", "[a *b*](x.md \"say \\\"hi\\\"\") ![alt](<y z.png>)\n"},
+		{"\\# not \\* a_b \\_c\\_ \\[x\\]", "\\# not \\* a_b \\_c\\_ \\[x\\]\n"},
+		{"> a\n>\n> b", "> a\n>\n> b\n"},
+		{"* a\n*\n* b", "* a\n*\n* b\n"},
+		{"{.note}\nPara", "{.note}\nPara\n"},
+		{"*** Part ", "*** Part\n"},
+		{"````go {2}\nfunc main() {\n```\n}\n````", "````go {2}\nfunc main() {\n```\n}\n````\n"},
+		{"~~~ `x`\ncode\n~~~", "~~~`x`\ncode\n~~~\n"},
+		{"**a *b***", "__a *b*__\n"},
+	}
+
+	for _, test := range tests {
+		seq, _ := mark.ParseContent(mark.VirtualDir{}, "main.md", []byte(test.In))
+		if got := markdown.Convert(seq); got != test.Exp {
+			t.Errorf("%q:\ngot %q\nexp %q", test.In, got, test.Exp)
+		}
+	}
+}
+
+func TestConvertFile(t *testing.T) {
+	fs := mark.VirtualDir{
+		"book/index.md":        "# Book\n\nSee [intro](../intro.md).\n\n{{chapter/one.md}}\n\nDone",
+		"book/chapter/one.md":  "## One\n\n![img](img.png)",
+		"intro.md":             "",
+		"book/chapter/img.png": "",
+	}
+	seq, errs := (&mark.Parser{}).ParseFile(fs, "book/index.md")
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+
+	got := markdown.ConvertFile("book/index.md", seq)
+	exp := "# Book\n\nSee [intro](../intro.md).\n\n{{chapter/one.md}}\n\nDone\n"
+	if got != exp {
+		t.Errorf("got\n%s\nexp\n%s", got, exp)
+	}
+
+	// without a path the included content is written inline
+	got = markdown.Convert(seq)
+	exp = "# Book\n\nSee [intro](intro.md).\n\n## One\n\n![img](book/chapter/img.png)\n\nDone\n"
+	if got != exp {
+		t.Errorf("got\n%s\nexp\n%s", got, exp)
+	}
+}
+
+func TestConvertFileIncludes(t *testing.T) {
+	fs := mark.VirtualDir{
+		"index.md": "# Book\n\n{{sh.md#two}}\n\nAfter two.\n\n{{ch.md level=+1}}\n\n{{ch/*.md}}\n\n{{ch.md}}\n\n## End\n",
+		"sh.md":    "# One\n\n# Two {#two}\n\nSecond.\n",
+		"ch.md":    "# Chapter\n\n{{ch/a.md}}\n",
+		"ch/a.md":  "# A\n\nFirst.\n",
+		"ch/b.md":  "# B\n",
+	}
+	seq, errs := (&mark.Parser{}).ParseFile(fs, "index.md")
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+
+	got := markdown.ConvertFile("index.md", seq)
+	if exp := fs["index.md"]; got != exp {
+		t.Errorf("got\n%s\nexp\n%s", got, exp)
+	}
+
+	fs["index.md"] = got
+	reparsed, errs := (&mark.Parser{}).ParseFile(fs, "index.md")
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	if !reflect.DeepEqual(marktest.ClearPositions(reparsed), marktest.ClearPositions(seq)) {
+		t.Errorf("round trip changed the document\ngot  %v\nexp  %v", reparsed, seq)
+	}
+}
+
+func TestConvertUnparsed(t *testing.T) {
+	type custom struct{ mark.Text }
+	markdown.RegisterInline(custom{}, func(item mark.Inline) string {
+		return "<" + item.(custom).Value + ">"
+	})
+
+	seq := mark.Sequence{
+		&mark.Paragraph{Items: []mark.Inline{
+			mark.Text{Value: "a"}, mark.HardBreak{}, mark.Text{Value: "b "},
+			mark.InlineModifier{Class: "x", Inline: mark.Emphasis{Items: []mark.Inline{mark.Text{Value: "c"}}}},
+			mark.Index{Term: "hidden"}, mark.Text{Value: " "},
+			mark.Ref{ID: "fig1"}, mark.Text{Value: " "},
+			custom{mark.Text{Value: "d"}},
+		}},
+		&mark.List{Ordered: true, Content: []mark.Sequence{
+			{&mark.Paragraph{Items: []mark.Inline{mark.Text{Value: "one"}}}},
+		}},
+	}
+	got := markdown.Convert(seq)
+	exp := "a\\\nb *c* [fig1](#fig1) <d>\n\n1. one\n"
+	if got != exp {
+		t.Errorf("got %q exp %q", got, exp)
+	}
+}
+
+// roundTripCases contain syntax that needs escaping
+var roundTripCases = []string{
+	"# Title #\n\n## a_b_ *c*",
+	"Text with \\* \\_ \\` \\[ \\] \\{ \\} \\\\ \\! and ! at the end!",
+	"\\# a\n\\> b\n\\- c\n\\+ d\n1\\. e\n\\=\n\\-\\-\\-",
+	"a\\\nb",
+	"\\!\\[x\\](y) \\!",
+	"*a **b** c* **a *b* c** ***x*** **x**y *x*_y_",
+	"`` ` `` `  ` ` a ` ```` ``` ````",
+	"[x](<a b> \"t\") [y](a\\(b\\)) [](<>) [z](http://x/?a=b&c=d 'q')",
+	"{{$undefined}} and \\{.class\\}",
+	"> # Quote\n>\n> * item\n>\n> ```\n> code\n> ```",
+	"* > quote\n*\n* ```\n* code\n* ```",
+	"```\n    ```\n``\n```",
+	"a_b_c _a_ __b__ snake_case_ _x",
+	"0***0*** a***b***c",
+	"\x17***0*** 00\xfc_x0*00*00_ 00",
+	"3) x\n2. y",
+	"<div>\n|table|",
+	"0**0 **0***0**",
+	"***0*00*0",
+	"*0***0*0*0",
+	"0*****0*****",
+	"A******************************V******************************",
+	"***********************!***************0**************0***********0*0**** 00",
+	"________0_ _0__*____!0_ 0*0_",
+	"_\\\n0_",
+	"](\x93)",
+	"](\r)",
+	"[](<\x00>) [](\\<)",
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, content := range roundTripCases {
+		testRoundTrip(t, content)
+	}
+
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 2000; i++ {
+		testRoundTrip(t, randomContent(rng))
+	}
+}
+
+func FuzzRoundTrip(f *testing.F) {
+	for _, content := range roundTripCases {
+		f.Add(content)
+	}
+	f.Fuzz(testRoundTrip)
+}
+
+// testRoundTrip checks that parsing the converted content gives the same result
+func testRoundTrip(t *testing.T, content string) {
+	t.Helper()
+	parse := func(content string) mark.Sequence {
+		seq, _ := mark.ParseContent(mark.VirtualDir{}, "main.md", []byte(content))
+		return marktest.ClearPositions(seq)
+	}
+
+	seq := parse(content)
+	out := markdown.Convert(seq)
+	if got := parse(out); !reflect.DeepEqual(got, seq) {
+		t.Errorf("%q: converted to %q\ngot %#v\nexp %#v", content, out, got, seq)
+	}
+}
+
+var (
+	inlineFragments = []string{
+		"word", "a_b", "_", "__", "*", "**", "`", "``", "\\", "[", "]", "(", ")",
+		"{", "}", "!", "#", "<", ">", "=", "-", "+", "~", "1.", "2)", ".", "\"",
+		"*em*", "**bold**", "_em_", "__bold__", "`code`", "`` a`b ``", "` `",
+		"[link](x.md)", "[**b**](<a b.md> \"t\")", "![alt](y.png 'tip')",
+		"{{$var}}", "\\*", "\\_", "\\#", "\\\\", "é",
+	}
+	blockPrefixes = []string{
+		"", "", "", "# ", "### ", "> ", "* ", "- ", "{.note}\n", "*** ", "    ",
+	}
+)
+
+// randomContent generates markdown from fragments that need escaping
+func randomContent(rng *rand.Rand) string {
+	var blocks []string
+	for b := rng.Intn(4) + 1; b > 0; b-- {
+		if rng.Intn(8) == 0 {
+			fence := strings.Repeat("`", rng.Intn(3)+3)
+			blocks = append(blocks, fence+"go\n"+strings.Repeat("`", rng.Intn(5))+"x\n"+fence)
+			continue
+		}
+
+		var lines []string
+		for l := rng.Intn(3) + 1; l > 0; l-- {
+			var line strings.Builder
+			for n := rng.Intn(6) + 1; n > 0; n-- {
+				line.WriteString(inlineFragments[rng.Intn(len(inlineFragments))])
+				if rng.Intn(2) == 0 {
+					line.WriteString(" ")
+				}
+			}
+			lines = append(lines, line.String())
+		}
+		blocks = append(blocks, blockPrefixes[rng.Intn(len(blockPrefixes))]+strings.Join(lines, "\n"))
+	}
+	return strings.Join(blocks, "\n\n")
+}
@@ -1,33 +1,44 @@
 package mark
 
-import "strings"
+import (
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
 
 type markup struct {
 	*parse
+	lines  []int // offsets of lines in the joined text
+	starts []int // offsets of lines in the file
 }
 
-func (markup markup) findnext(delim rune, level int, tokens []token, start int) int {
-	for i, t := range tokens[start:] {
-		if t.isempty() {
-			continue
-		}
-		if t.delim == delim && level == t.level {
-			return start + i
-		}
+func (parse *parse) newMarkup(lines []string, starts []int) markup {
+	markup := markup{parse: parse, starts: starts}
+	offset := 0
+	for _, line := range lines {
+		markup.lines = append(markup.lines, offset)
+		offset += len(line) + 1
 	}
-	return -1
+	return markup
 }
 
-func (markup markup) findnextdelim(delim rune, tokens []token, start int) int {
-	for i, t := range tokens[start:] {
-		if t.isempty() {
-			continue
-		}
-		if t.delim == delim && t.level > 0 {
-			return start + i
-		}
+// offset converts an offset in the joined text to an offset in the file
+func (markup markup) offset(at int) int {
+	// index of the last line starting at or before at
+	k := sort.SearchInts(markup.lines, at+1) - 1
+	if k < 0 {
+		k = 0
 	}
-	return -1
+	if k >= len(markup.starts) {
+		return at
+	}
+	return markup.starts[k] + at - markup.lines[k]
+}
+
+// position returns the position of the joined text between offsets
+func (markup markup) position(from, to int) Position {
+	return markup.parse.position(markup.offset(from), markup.offset(to))
 }
 
 func (markup markup) cloneTokens(tokens []token) []token {
@@ -36,187 +47,329 @@ func (markup markup) cloneTokens(tokens []token) []token {
 	return r
 }
 
-func (markup markup) simple(tokens []token, links bool) (resolved []token) {
+// findclose finds the `]` that balances the `[` at start
+func (markup markup) findclose(tokens []token, start int) int {
+	depth := 0
+	for i := start; i < len(tokens); i++ {
+		switch tokens[i].delim {
+		case '[':
+			depth++
+		case ']':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+func (markup markup) simple(tokens []token) (resolved []token) {
 	for s := 0; s < len(tokens); s++ {
 		t := tokens[s]
 		if t.isempty() {
 			continue
 		}
 
-		if links {
-			switch t.delim {
-			case '`':
-				e := markup.findnext('`', t.level, tokens, s+1)
-				if e < 0 {
-					resolved = append(resolved, t)
-					continue
-				}
-				resolved = append(resolved, token{elem: CodeSpan(markup.rawtext(tokens[s+1 : e]))})
-				s = e
-			case '!':
-				// TODO: implement alternate captions `![Alt text](/path/to/img.jpg "Optional title")`
-				// TODO: implement reference links `![Alt text][id]`
-				//                                 `[id]: url/to/image  "Optional title attribute"`
-				if s+1 >= len(tokens) || tokens[s].level != 1 || tokens[s+1].delim != '[' {
-					resolved = append(resolved, t)
-					continue
-				}
-
-				capstart := s + 1
-				capeend := markup.findnextdelim(']', tokens, capstart+1)
-				if capeend < 0 || capeend+1 >= len(tokens) || tokens[capeend+1].delim != '(' {
-					resolved = append(resolved, t)
-					continue
-				}
-				linkend := markup.findnextdelim(')', tokens, capeend+1)
-				if linkend < 0 {
-					resolved = append(resolved, t)
-					continue
-				}
+		switch t.delim {
+		case '!':
+			// TODO: implement reference links `![Alt text][id]`
+			//                                 `[id]: url/to/image  "Optional title attribute"`
+			if s+1 >= len(tokens) || tokens[s].level != 1 || tokens[s+1].delim != '[' {
+				resolved = append(resolved, t)
+				continue
+			}
 
-				caption := markup.cloneTokens(tokens[capstart : capeend+1])
-				link := markup.cloneTokens(tokens[capeend+1 : linkend+1])
+			capend := markup.findclose(tokens, s+1)
+			if capend < 0 || capend+1 >= len(tokens) || tokens[capend+1].tail == nil {
+				resolved = append(resolved, t)
+				continue
+			}
 
-				// remove wrapping []
-				caption[0].level--
-				caption[len(caption)-1].level--
-				// remove wrapping ()
-				link[0].level--
-				link[len(link)-1].level--
+			caption := markup.cloneTokens(tokens[s+2 : capend])
+			tail := tokens[capend+1]
 
-				href := markup.reltoabs(markup.rawtext(link))
-				markup.checkPathExists(href)
+			tailpos := markup.position(tail.pos+1, tail.end-1)
+			href := markup.reltoabs(markup.substituteVariables(tail.tail.dest, tailpos))
+			markup.checkPathExists(href, tailpos)
 
-				resolved = append(resolved, token{
-					elem: Image{
-						Alt:  Paragraph{markup.resolve(caption)},
-						Href: href,
+			resolved = append(resolved, token{
+				elem: Image{
+					Position: markup.position(t.pos, tail.end),
+					Alt: Paragraph{
+						Position: markup.position(tokens[s+1].end, tokens[capend].pos),
+						Items:    markup.resolve(caption),
 					},
-				})
-				s = linkend
-			case '[':
-				//TODO: implement title attribute `[an example](http://example.com/ "Title")`
-				//TODO: implement reference links `This is [an example][id] reference-style link.`
-				//                                `[id]: example.com  "Optional title attribute"`
-				capstart := s
-				capeend := markup.findnextdelim(']', tokens, capstart+1)
-				if capeend < 0 || capeend+1 >= len(tokens) || tokens[capeend+1].delim != '(' {
-					resolved = append(resolved, t)
-					continue
-				}
-				linkend := markup.findnextdelim(')', tokens, capeend+1)
-				if linkend < 0 {
-					resolved = append(resolved, t)
-					continue
-				}
-
-				caption := markup.cloneTokens(tokens[capstart : capeend+1])
-				link := markup.cloneTokens(tokens[capeend+1 : linkend+1])
+					Href:    href,
+					Tooltip: markup.substituteVariables(tail.tail.title, tailpos),
+				},
+				pos: t.pos,
+				end: tail.end,
+			})
+			s = capend + 1
+		case '[':
+			//TODO: implement reference links `This is [an example][id] reference-style link.`
+			//                                `[id]: example.com  "Optional title attribute"`
+			capend := markup.findclose(tokens, s)
+			if capend < 0 || capend+1 >= len(tokens) || tokens[capend+1].tail == nil {
+				resolved = append(resolved, t)
+				continue
+			}
 
-				// remove wrapping []
-				caption[0].level--
-				caption[len(caption)-1].level--
-				// remove wrapping ()
-				link[0].level--
-				link[len(link)-1].level--
+			caption := markup.cloneTokens(tokens[s+1 : capend])
+			tail := tokens[capend+1]
 
-				href := markup.reltoabs(markup.rawtext(link))
-				markup.checkPathExists(href)
+			tailpos := markup.position(tail.pos+1, tail.end-1)
+			href := markup.reltoabs(markup.substituteVariables(tail.tail.dest, tailpos))
+			markup.checkPathExists(href, tailpos)
 
-				resolved = append(resolved, token{
-					elem: Link{
-						Title: Paragraph{markup.resolve(caption)},
-						Href:  href,
+			resolved = append(resolved, token{
+				elem: Link{
+					Position: markup.position(t.pos, tail.end),
+					Title: Paragraph{
+						Position: markup.position(t.end, tokens[capend].pos),
+						Items:    markup.resolve(caption),
 					},
-				})
-				s = linkend
-			default:
-				resolved = append(resolved, t)
+					Href:    href,
+					Tooltip: markup.substituteVariables(tail.tail.title, tailpos),
+				},
+				pos: t.pos,
+				end: tail.end,
+			})
+			s = capend + 1
+		default:
+			resolved = append(resolved, t)
+		}
+	}
+	return
+}
+
+// emphasis resolves `*` and `_` delimiter runs into Emphasis and Bold
+// using the CommonMark delimiter stack,
+// http://spec.commonmark.org/0.31.2/#phase-2-inline-structure
+func (markup markup) emphasis(tokens []token) []token {
+	if len(tokens) == 0 {
+		return tokens
+	}
+
+	nodes := make([]tokenNode, len(tokens))
+	var closer, stack *tokenNode
+	for i := range tokens {
+		node := &nodes[i]
+		node.token = tokens[i]
+		node.index = i
+		if i > 0 {
+			node.prev = &nodes[i-1]
+			nodes[i-1].next = node
+		}
+		if node.isEmphasis() {
+			node.below = stack
+			if stack != nil {
+				stack.above = node
+			} else {
+				closer = node
 			}
-		} else {
-			switch t.delim {
-			case '*', '_':
-				e := markup.findnextdelim(t.delim, tokens, s+1)
-				if e < 0 {
-					resolved = append(resolved, t)
-					continue
-				}
+			stack = node
+		}
+	}
 
-				minlevel := t.level
-				if minlevel > tokens[e].level {
-					minlevel = tokens[e].level
-				}
+	// index of the delimiter below which no opener can match,
+	// keyed by closer delimiter, run%3 and whether it can open
+	type bottomKey struct {
+		delim   rune
+		run     int
+		canOpen bool
+	}
+	openersBottom := map[bottomKey]int{}
 
-				content := markup.cloneTokens(tokens[s:e])
-				content[0].level -= minlevel
-				tokens[e].level -= minlevel
+	for closer != nil {
+		if !closer.canClose() {
+			closer = closer.above
+			continue
+		}
 
-				if minlevel >= 2 {
-					resolved = append(resolved, token{elem: Bold(markup.resolve(content))})
-				} else {
-					resolved = append(resolved, token{elem: Emphasis(markup.resolve(content))})
-				}
+		key := bottomKey{closer.delim, closer.run % 3, closer.canOpen()}
+		bottom, ok := openersBottom[key]
+		if !ok {
+			bottom = -1
+		}
 
-				s = e - 1
-			default:
-				resolved = append(resolved, t)
+		opener := closer.below
+		for ; opener != nil && opener.index > bottom; opener = opener.below {
+			if opener.delim != closer.delim || !opener.canOpen() {
+				continue
+			}
+			// rule of 3
+			if (opener.canClose() || closer.canOpen()) &&
+				(opener.run+closer.run)%3 == 0 &&
+				!(opener.run%3 == 0 && closer.run%3 == 0) {
+				continue
 			}
+			break
+		}
+		if opener == nil || opener.index <= bottom {
+			openersBottom[key] = -1
+			if closer.below != nil {
+				openersBottom[key] = closer.below.index
+			}
+			next := closer.above
+			if !closer.canOpen() {
+				closer.unstack()
+			}
+			closer = next
+			continue
+		}
+
+		use := 1
+		if opener.level >= 2 && closer.level >= 2 {
+			use = 2
+		}
+		// opener is used from the end and closer from the start of the run
+		opener.level -= use
+		start, _ := opener.span()
+		start += opener.level
+		closer.level -= use
+		closer.front += use
+		end, _ := closer.span()
+
+		var content []token
+		for node := opener.next; node != closer; node = node.next {
+			content = append(content, node.token)
+		}
+		pos, items := markup.position(start, end), markup.inlines(content)
+		var elem Inline = Emphasis{pos, items}
+		if use == 2 {
+			elem = Bold{pos, items}
+		}
+
+		// replace content with the element
+		wrapped := &tokenNode{
+			token: token{elem: elem, pos: start, end: end},
+			prev:  opener,
+			next:  closer,
+		}
+		opener.next, closer.prev = wrapped, wrapped
+
+		// delimiters inside content can't match anymore
+		opener.above, closer.below = closer, opener
+		if opener.level == 0 {
+			opener.unstack()
+		}
+		if closer.level == 0 {
+			next := closer.above
+			closer.unstack()
+			closer = next
 		}
 	}
-	return
+
+	resolved := make([]token, 0, len(tokens))
+	for node := &nodes[0]; node != nil; node = node.next {
+		resolved = append(resolved, node.token)
+	}
+	return resolved
+}
+
+// tokenNode links tokens and emphasis delimiters for resolving emphasis
+type tokenNode struct {
+	token
+	index        int        // index of the delimiter in the paragraph
+	prev, next   *tokenNode // neighbouring tokens
+	below, above *tokenNode // neighbouring delimiters in the delimiter stack
+}
+
+// unstack removes node from the delimiter stack
+func (node *tokenNode) unstack() {
+	if node.below != nil {
+		node.below.above = node.above
+	}
+	if node.above != nil {
+		node.above.below = node.below
+	}
+	node.below, node.above = nil, nil
 }
 
 func (markup markup) text(tokens []token) (resolved []token) {
-	text := ""
+	var text strings.Builder
+	start, end := 0, 0
+	flush := func() {
+		if text.Len() > 0 {
+			resolved = append(resolved, token{
+				elem: Text{markup.position(start, end), text.String()},
+				pos:  start,
+				end:  end,
+			})
+			text.Reset()
+		}
+	}
 	for _, t := range tokens {
 		if t.isempty() {
 			continue
 		}
-		if t.elem != nil {
-			if text != "" {
-				resolved = append(resolved, token{elem: Text(text)})
-				text = ""
+		from, to := t.span()
+		if t.tail != nil && strings.Contains(t.text, "\n") {
+			// unmatched link tail spanning lines keeps the soft breaks
+			at := t.pos
+			for i, line := range strings.Split(t.text, "\n") {
+				if i > 0 {
+					flush()
+					resolved = append(resolved, token{elem: SoftBreak{markup.position(at-1, at)}, pos: at - 1, end: at})
+				}
+				if line != "" {
+					if text.Len() == 0 {
+						start = at
+					}
+					text.WriteString(line)
+					end = at + len(line)
+				}
+				at += len(line) + 1
 			}
-			resolved = append(resolved, token{elem: t.elem})
-		} else {
-			text += t.String()
+			continue
 		}
+		if _, ok := t.elem.(Text); ok || t.elem == nil {
+			if text.Len() == 0 {
+				start = from
+			}
+			text.WriteString(t.String())
+			end = to
+			continue
+		}
+		flush()
+		resolved = append(resolved, token{elem: t.elem, pos: t.pos, end: t.end})
 	}
-	if text != "" {
-		resolved = append(resolved, token{elem: Text(text)})
-	}
+	flush()
 	return
 }
 
-func (markup markup) rawtext(tokens []token) (text string) {
-	for _, t := range tokens {
-		text += t.String()
-	}
-	return
+func (markup markup) resolve(tokens []token) []Inline {
+	tokens = markup.simple(tokens)
+	tokens = markup.emphasis(tokens)
+	return markup.inlines(tokens)
 }
 
-func (markup markup) resolve(tokens []token) []Inline {
-	tokens = markup.simple(tokens, true)
-	tokens = markup.simple(tokens, false)
+// inlines converts tokens into inline elements, remaining delimiters become text
+func (markup markup) inlines(tokens []token) []Inline {
 	tokens = markup.text(tokens)
 	var inlines []Inline
 	for _, t := range tokens {
-		if t.elem == nil {
-			panic("unhandled delimiter or text")
-		}
-		inlines = append(inlines, t.elem)
+		inlines = append(inlines, t.Inline())
 	}
 	return inlines
 }
 
-func (parse *parse) linesToParagraph(lines []string) *Paragraph {
-	return &Paragraph{markup{parse}.resolve(tokenizeLines(lines))}
+// linesToParagraph parses lines starting at file offsets starts
+func (parse *parse) linesToParagraph(lines []string, starts []int) *Paragraph {
+	markup := parse.newMarkup(lines, starts)
+	text := strings.Join(lines, "\n")
+	return &Paragraph{
+		Position: markup.position(0, len(text)),
+		Items:    markup.resolve(markup.tokenize(text)),
+	}
 }
 
 /* tokenization */
 func markupDelimiter(r rune) bool {
 	switch r {
-	case '`', '[', ']', '(', ')', '*', '_', '!':
+	case '[', ']', '*', '_', '!':
 		return true
 	}
 	return false
@@ -227,59 +380,380 @@ type token struct {
 	level int
 	text  string
 	elem  Inline
+	tail  *linkTail
+
+	pos, end int // range in the joined text
+	front    int // number of delimiters used from the start of the run
+
+	run           int  // length of the delimiter run
+	before, after rune // characters surrounding the delimiter run, 0 for paragraph ends
+}
+
+// linkTail is the `(destination "title")` part of an inline link
+type linkTail struct {
+	dest  string
+	title string
+}
+
+// span returns the range of unused delimiters or the range of the token
+func (t *token) span() (from, to int) {
+	if t.delim != 0 {
+		return t.pos + t.front, t.pos + t.front + t.level
+	}
+	return t.pos, t.end
+}
+
+func (t *token) isEmphasis() bool { return t.delim == '*' || t.delim == '_' }
+
+// leftFlanking checks whether delimiter run can start emphasis,
+// http://spec.commonmark.org/0.31.2/#left-flanking-delimiter-run
+func (t *token) leftFlanking() bool {
+	return !isSpace(t.after) && (!isPunct(t.after) || isSpace(t.before) || isPunct(t.before))
+}
+
+// rightFlanking checks whether delimiter run can end emphasis
+func (t *token) rightFlanking() bool {
+	return !isSpace(t.before) && (!isPunct(t.before) || isSpace(t.after) || isPunct(t.after))
+}
+
+func (t *token) canOpen() bool {
+	if t.delim == '_' {
+		return t.leftFlanking() && (!t.rightFlanking() || isPunct(t.before))
+	}
+	return t.leftFlanking()
+}
+
+func (t *token) canClose() bool {
+	if t.delim == '_' {
+		return t.rightFlanking() && (!t.leftFlanking() || isPunct(t.after))
+	}
+	return t.rightFlanking()
+}
+
+func isSpace(r rune) bool { return r == 0 || unicode.IsSpace(r) }
+func isPunct(r rune) bool { return r != 0 && (unicode.IsPunct(r) || unicode.IsSymbol(r)) }
+
+// isEscapable reports whether r can be escaped with a backslash
+func isEscapable(r rune) bool {
+	return r < utf8.RuneSelf && r != 0 && strings.ContainsRune("!\"#$%&'()*+,-./:;<=>?@[\\]^_`{|}~", r)
 }
 
-func tokenizeLines(lines []string) (tokens []token) {
-	pushdelim := func(r rune) {
+func (markup markup) tokenize(text string) (tokens []token) {
+	var prev, before rune // previous characters in the paragraph
+	pushdelim := func(r rune, from, to int) {
 		n := len(tokens) - 1
-		canadd := n >= 0 && tokens[n].elem == nil
-		if canadd && tokens[n].delim == r {
+		canadd := n >= 0 && tokens[n].elem == nil && tokens[n].tail == nil
+		if canadd && tokens[n].delim == r && r != '[' && r != ']' {
 			tokens[n].level++
+			tokens[n].run++
+			tokens[n].end = to
 		} else {
-			tokens = append(tokens, token{delim: r, level: 1})
+			tokens = append(tokens, token{delim: r, level: 1, run: 1, before: before, pos: from, end: to})
 		}
 	}
 
-	pushrune := func(r rune) {
+	// text of the last text token is collected into pending
+	pending, pendingToken := []byte{}, -1
+	flushPending := func() {
+		if pendingToken >= 0 {
+			tokens[pendingToken].text = string(pending)
+		}
+	}
+	// invalid UTF-8 is kept as is, the same as in code spans and link tails
+	pushtext := func(s string, from, to int) {
 		n := len(tokens) - 1
-		canadd := n >= 0 && tokens[n].elem == nil
-		if canadd && tokens[n].delim == 0 {
-			tokens[n].text += string(r)
+		canadd := n >= 0 && tokens[n].elem == nil && tokens[n].tail == nil
+		if canadd && tokens[n].delim == 0 && n == pendingToken {
+			pending = append(pending, s...)
+			tokens[n].end = to
 		} else {
-			tokens = append(tokens, token{text: string(r)})
+			flushPending()
+			tokens = append(tokens, token{pos: from, end: to})
+			pending, pendingToken = append(pending[:0], s...), len(tokens)-1
 		}
 	}
 
-	for i, line := range lines {
-		escapenext := false
-		for _, r := range line {
-			if escapenext {
-				pushrune(r)
-				escapenext = false
-				continue
-			}
-			if r == '\\' {
-				escapenext = true
-				continue
+	escapenext := false
+	for k := 0; k < len(text); {
+		r, size := utf8.DecodeRuneInString(text[k:])
+		if n := len(tokens) - 1; n >= 0 && tokens[n].delim != 0 && tokens[n].after == 0 {
+			if r != tokens[n].delim || escapenext {
+				tokens[n].after = r
 			}
+		}
+		before, prev = prev, r
 
-			if markupDelimiter(r) {
-				pushdelim(r)
+		if r == '\n' {
+			escapenext = false
+			tokens = append(tokens, token{
+				elem: SoftBreak{markup.position(k, k+size)},
+				pos:  k,
+				end:  k + size,
+			})
+			k += size
+			continue
+		}
+		if escapenext {
+			escapenext = false
+			if !isEscapable(r) {
+				pushtext("\\", k-1, k)
+			}
+			pushtext(text[k:k+size], k-1, k+size)
+			k += size
+			continue
+		}
+		if r == '\\' {
+			escapenext = true
+			k += size
+			continue
+		}
+		if r == '`' {
+			elem, n := markup.codeSpan(text[k:], k)
+			if elem == nil {
+				// unmatched backtick run is literal
+				for i := 0; i < n; i++ {
+					pushtext("`", k+i, k+i+1)
+				}
 			} else {
-				pushrune(r)
+				tokens = append(tokens, token{elem: elem, text: text[k : k+n], pos: k, end: k + n})
+			}
+			k += n
+			continue
+		}
+		if r == '(' {
+			if n := len(tokens) - 1; n >= 0 && tokens[n].delim == ']' {
+				if dest, title, n := parseLinkTail(text[k:]); n > 0 {
+					tokens = append(tokens, token{
+						tail: &linkTail{dest: dest, title: title},
+						text: text[k : k+n],
+						pos:  k,
+						end:  k + n,
+					})
+					k += n
+					continue
+				}
 			}
 		}
+		if r == '{' {
+			if name, n := variableAt(text[k:]); n > 0 {
+				if value, ok := markup.lookupVariable(name, markup.position(k, k+n)); ok {
+					tokens = append(tokens, token{
+						elem: Text{markup.position(k, k+n), value},
+						pos:  k,
+						end:  k + n,
+					})
+					k += n
+					continue
+				}
+			}
+		}
+		if elem, n := markup.parseCustomInline(text[k:], k); n > 0 {
+			tokens = append(tokens, token{elem: elem, text: text[k : k+n], pos: k, end: k + n})
+			k += n
+			continue
+		}
 
-		if i+1 != len(lines) {
-			tokens = append(tokens, token{
-				elem: SoftBreak{},
-			})
+		if markupDelimiter(r) {
+			pushdelim(r, k, k+size)
+		} else {
+			pushtext(text[k:k+size], k, k+size)
 		}
+		k += size
 	}
 
+	flushPending()
 	return tokens
 }
 
+// codeSpan parses a code span starting with a backtick run,
+// http://spec.commonmark.org/0.31.2/#code-spans
+//
+// When there is no closing run of equal length, it returns a nil
+// elem and the length of the opening run.
+func (markup markup) codeSpan(text string, at int) (Inline, int) {
+	open := backtickRun(text)
+	for i := open; i < len(text); {
+		if text[i] != '`' {
+			i++
+			continue
+		}
+		n := backtickRun(text[i:])
+		if n != open {
+			i += n
+			continue
+		}
+
+		content := strings.Replace(text[open:i], "\n", " ", -1)
+		if len(content) >= 2 && content[0] == ' ' && content[len(content)-1] == ' ' &&
+			strings.Trim(content, " ") != "" {
+			content = content[1 : len(content)-1]
+		}
+		pos := markup.position(at, at+i+n)
+		return CodeSpan{pos, markup.substituteVariables(content, pos)}, i + n
+	}
+	return nil, open
+}
+
+func backtickRun(text string) int {
+	n := 0
+	for n < len(text) && text[n] == '`' {
+		n++
+	}
+	return n
+}
+
+// substituteVariables replaces defined `{{$name}}` references in text at pos
+func (markup markup) substituteVariables(text string, pos Position) string {
+	if !strings.Contains(text, "{{$") {
+		return text
+	}
+	var r strings.Builder
+	for k := 0; k < len(text); k++ {
+		if text[k] == '{' {
+			if name, n := variableAt(text[k:]); n > 0 {
+				if value, ok := markup.lookupVariable(name, pos); ok {
+					r.WriteString(value)
+					k += n - 1
+					continue
+				}
+			}
+		}
+		r.WriteByte(text[k])
+	}
+	return r.String()
+}
+
+// parseLinkTail parses `(destination "title")` of an inline link,
+// http://spec.commonmark.org/0.31.2/#inline-link
+//
+// It returns the number of bytes consumed or 0, when text does not
+// start with a valid link tail.
+func parseLinkTail(text string) (dest, title string, size int) {
+	if !strings.HasPrefix(text, "(") {
+		return "", "", 0
+	}
+	i := skipLinkSpace(text, 1)
+
+	// destination
+	if i < len(text) && text[i] == '<' {
+		end := -1
+		for k := i + 1; k < len(text); k++ {
+			if text[k] == '\\' && k+1 < len(text) {
+				k++
+				continue
+			}
+			if text[k] == '\n' || text[k] == '<' {
+				return "", "", 0
+			}
+			if text[k] == '>' {
+				end = k
+				break
+			}
+		}
+		if end < 0 {
+			return "", "", 0
+		}
+		dest = unescapeText(text[i+1 : end])
+		i = end + 1
+	} else {
+		start, depth := i, 0
+	scan:
+		for ; i < len(text); i++ {
+			switch c := text[i]; {
+			case c == '\\' && i+1 < len(text) && isEscapable(rune(text[i+1])):
+				i++
+			case c == '(':
+				depth++
+			case c == ')':
+				if depth == 0 {
+					break scan
+				}
+				depth--
+			case c <= ' ' || c == 0x7f:
+				break scan
+			}
+		}
+		if depth != 0 {
+			return "", "", 0
+		}
+		dest = unescapeText(text[start:i])
+	}
+
+	// title, which must be separated from the destination
+	k := skipLinkSpace(text, i)
+	if k > i && k < len(text) {
+		var close byte
+		switch text[k] {
+		case '"':
+			close = '"'
+		case '\'':
+			close = '\''
+		case '(':
+			close = ')'
+		}
+		if close != 0 {
+			end := -1
+			for p := k + 1; p < len(text); p++ {
+				if text[p] == '\\' && p+1 < len(text) {
+					p++
+					continue
+				}
+				if text[p] == close {
+					end = p
+					break
+				}
+				if close == ')' && text[p] == '(' {
+					return "", "", 0
+				}
+			}
+			if end < 0 {
+				return "", "", 0
+			}
+			title = unescapeText(text[k+1 : end])
+			k = skipLinkSpace(text, end+1)
+		}
+	}
+	i = k
+
+	if i >= len(text) || text[i] != ')' {
+		return "", "", 0
+	}
+	return dest, title, i + 1
+}
+
+// skipLinkSpace skips whitespace, including at most one line ending
+func skipLinkSpace(text string, i int) int {
+	newline := false
+	for ; i < len(text); i++ {
+		switch text[i] {
+		case ' ', '\t':
+		case '\n':
+			if newline {
+				return i
+			}
+			newline = true
+		default:
+			return i
+		}
+	}
+	return i
+}
+
+// unescapeText removes backslashes before escapable characters
+func unescapeText(text string) string {
+	if !strings.Contains(text, "\\") {
+		return text
+	}
+	var r strings.Builder
+	for k := 0; k < len(text); k++ {
+		if text[k] == '\\' && k+1 < len(text) && isEscapable(rune(text[k+1])) {
+			k++
+		}
+		r.WriteByte(text[k])
+	}
+	return r.String()
+}
+
 func (t *token) isempty() bool {
 	return t.text == "" && t.level == 0 && t.elem == nil
 }
@@ -292,13 +766,20 @@ func (t *token) String() string {
 		return strings.Repeat(string(t.delim), t.level)
 	}
 	if t.elem != nil {
+		if text, ok := t.elem.(Text); ok {
+			return text.Value
+		}
+		if t.text != "" {
+			// custom inline syntax
+			return t.text
+		}
 		if _, ok := t.elem.(SoftBreak); ok {
 			return "\n"
 		}
 		if _, ok := t.elem.(HardBreak); ok {
 			return "\n"
 		}
-		panic("invalid token to String conversion")
+		return ""
 	}
 	return t.text
 }
@@ -307,5 +788,5 @@ func (t *token) Inline() Inline {
 	if t.elem != nil {
 		return t.elem
 	}
-	return Text(t.String())
+	return Text{Value: t.String()}
 }
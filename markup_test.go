@@ -1,8 +1,12 @@
 package mark_test
 
-import "testing"
+import (
+	"strings"
+	"testing"
 
-const skipNestedBoldEm = true
+	"github.com/loov/mark"
+	"github.com/loov/mark/html"
+)
 
 func TestBoldEmphasis(t *testing.T) {
 	TestCases{{ // emphasis and bold
@@ -11,18 +15,18 @@ func TestBoldEmphasis(t *testing.T) {
 			Text("Paragraph "),
 			Em(Text("x")), Text(" "),
 			Bold(Text("x")), Text(" "),
-			Bold(Text("x")), Text(" "),
-			Bold(Text("x")), Text(" "),
-			Bold(Text("x")), Text("."),
+			Em(Bold(Text("x"))), Text(" "),
+			Bold(Bold(Text("x"))), Text(" "),
+			Em(Bold(Bold(Text("x")))), Text("."),
 		)),
 	}, { // emphasis and bold, no leading text
 		In: "*x* **x** ***x*** ****x**** *****x*****.",
 		Exp: Seq(Para(
 			Em(Text("x")), Text(" "),
 			Bold(Text("x")), Text(" "),
-			Bold(Text("x")), Text(" "),
-			Bold(Text("x")), Text(" "),
-			Bold(Text("x")), Text("."),
+			Em(Bold(Text("x"))), Text(" "),
+			Bold(Bold(Text("x"))), Text(" "),
+			Em(Bold(Bold(Text("x")))), Text("."),
 		)),
 	}, { // emphasis and bold side-by-side
 		In: "*x***x**",
@@ -40,26 +44,44 @@ func TestBoldEmphasis(t *testing.T) {
 		In:  "* x *",
 		Exp: Seq(Ul(Seq(Para(Text("x *"))))),
 	}, { // bold nested in em
-		In:   "****x*** testing*",
-		Exp:  Seq(Para(Em(Bold(Text("x")), Text(" testing")))),
-		Skip: skipNestedBoldEm,
+		In:  "****x*** testing*",
+		Exp: Seq(Para(Em(Em(Bold(Text("x"))), Text(" testing")))),
 	}, { // bold nested in em
-		In:   "*testing ***x****",
-		Exp:  Seq(Para(Em(Text("testing "), Bold(Text("x"))))),
-		Skip: skipNestedBoldEm,
+		In:  "*testing ***x****",
+		Exp: Seq(Para(Em(Text("testing "), Em(Bold(Text("x")))))),
 	}, { // em nested in bold
-		In:   "****x* testing***",
-		Exp:  Seq(Para(Bold(Em(Text("x")), Text(" testing")))),
-		Skip: skipNestedBoldEm,
+		In:  "****x* testing***",
+		Exp: Seq(Para(Em(Bold(Em(Text("x")), Text(" testing"))))),
 	}, { // em nested in bold
-		In:   "***testing *x****",
-		Exp:  Seq(Para(Bold(Text("testing "), Em(Text("x"))))),
-		Skip: skipNestedBoldEm,
+		In:  "***testing *x****",
+		Exp: Seq(Para(Em(Bold(Text("testing "), Em(Text("x")))))),
+	}, { // intraword underscores
+		In:  "snake_case_name and _foo_bar_",
+		Exp: Seq(Para(Text("snake_case_name and "), Em(Text("foo_bar")))),
+	}, { // intraword asterisks
+		In:  "foo*bar*baz",
+		Exp: Seq(Para(Text("foo"), Em(Text("bar")), Text("baz"))),
+	}, { // unbalanced
+		In:  "**bold*",
+		Exp: Seq(Para(Text("*"), Em(Text("bold")))),
+	}, { // surrounded by whitespace
+		In:  "a * b * c",
+		Exp: Seq(Para(Text("a * b * c"))),
+	}, { // rule of 3
+		In:  "*foo**bar*",
+		Exp: Seq(Para(Em(Text("foo**bar")))),
+	}, { // nested runs
+		In:  "*foo**bar**baz* __x__",
+		Exp: Seq(Para(Em(Text("foo"), Bold(Text("bar")), Text("baz")), Text(" "), Bold(Text("x")))),
+	}, { // punctuation
+		In:  "x *(y)* \"*z*\"",
+		Exp: Seq(Para(Text("x "), Em(Text("(y)")), Text(" \""), Em(Text("z")), Text("\""))),
+	}, { // escaped
+		In:  "*\\**",
+		Exp: Seq(Para(Em(Text("*")))),
 	}}.Run(t)
 }
 
-const skipPriorityCodeSpan = true
-
 func TestCodeSpan(t *testing.T) {
 	TestCases{{ // simple codespan
 		In:  "Start `Hello ` World",
@@ -74,9 +96,20 @@ func TestCodeSpan(t *testing.T) {
 		In:  "Start ````A```B````C```",
 		Exp: Seq(Para(Text("Start "), CodeSpan("A```B"), Text("C```"))),
 	}, { // nested reverse
-		In:   "Start ```C````A```B````",
-		Exp:  Seq(Para(Text("Start ```C"), CodeSpan("A```B"))),
-		Skip: skipPriorityCodeSpan,
+		In:  "Start ```C````A```B````",
+		Exp: Seq(Para(Text("Start "), CodeSpan("C````A"), Text("B````"))),
+	}, { // stripped spaces
+		In:  "`` `a` `` and ` b `",
+		Exp: Seq(Para(CodeSpan("`a`"), Text(" and "), CodeSpan("b"))),
+	}, { // only spaces
+		In:  "`  `",
+		Exp: Seq(Para(CodeSpan("  "))),
+	}, { // across lines
+		In:  "`a\nb`",
+		Exp: Seq(Para(CodeSpan("a b"))),
+	}, { // no escapes
+		In:  "`\\*` \\`x`",
+		Exp: Seq(Para(CodeSpan("\\*"), Text(" `x`"))),
 	}, { // containing link
 		In:  "`[Link](Text)`",
 		Exp: Seq(Para(CodeSpan("[Link](Text)"))),
@@ -112,5 +145,150 @@ func TestLinks(t *testing.T) {
 	}, { // link takes precedence
 		In:  "*[x*](http://example.com)",
 		Exp: Seq(Para(Text("*"), Link("http://example.com", Text("x*")))),
+	}, { // nested brackets
+		In:  "[a [b] c](http://example.com)",
+		Exp: Seq(Para(Link("http://example.com", Text("a [b] c")))),
+	}, { // code span in caption
+		In:  "[`]`](http://example.com)`",
+		Exp: Seq(Para(Link("http://example.com", CodeSpan("]")), Text("`"))),
+	}, { // nested parentheses
+		In:  "[x](http://example.com/a(b)c)",
+		Exp: Seq(Para(Link("http://example.com/a(b)c", Text("x")))),
+	}, { // escaped parentheses
+		In:  "[x](http://example.com/\\(b)",
+		Exp: Seq(Para(Link("http://example.com/(b", Text("x")))),
+	}, { // pointy brackets
+		In:  "[x](<http://example.com/a b> \"t\")",
+		Exp: Seq(Para(Tooltip(Link("http://example.com/a b", Text("x")), "t"))),
+	}, { // titles
+		In: "[x]( http://example.com  \"a\" ) [y](http://example.com 'b')\n[z](http://example.com\n(c))",
+		Exp: Seq(Para(
+			Tooltip(Link("http://example.com", Text("x")), "a"), Text(" "),
+			Tooltip(Link("http://example.com", Text("y")), "b"), SB,
+			Tooltip(Link("http://example.com", Text("z")), "c"),
+		)),
+	}, { // invalid title
+		In:  "[x](http://example.com \"t\" z)",
+		Exp: Seq(Para(Text("[x](http://example.com \"t\" z)"))),
+	}, { // space before destination
+		In:  "[x] (http://example.com)",
+		Exp: Seq(Para(Text("[x] (http://example.com)"))),
+	}, { // unmatched destination spanning lines
+		In:  "a](b\nc)",
+		Exp: Seq(Para(Text("a](b"), SB, Text("c)"))),
+	}, { // invalid UTF-8 is kept
+		In:  "\xff ](\xff) \\\xff",
+		Exp: Seq(Para(Text("\xff ](\xff) \\\xff"))),
+	}, { // image with title
+		In: "![*a*](http://example.com/i.png \"T\")",
+		Exp: Seq(Para(mark.Image{
+			Alt:     *Para(Em(Text("a"))),
+			Href:    "http://example.com/i.png",
+			Tooltip: "T",
+		})),
 	}}.Run(t)
 }
+
+type Mention struct {
+	mark.Position
+	Name string
+}
+
+func (Mention) TagInline() {}
+
+type WikiLink struct {
+	mark.Position
+	Page []mark.Inline
+}
+
+func (WikiLink) TagInline() {}
+
+var customInlines = &mark.Parser{
+	Inlines: []mark.InlineSyntax{{
+		Triggers: "@",
+		Parse: func(ctx *mark.InlineContext, text string) (mark.Inline, int) {
+			size := 1
+			for size < len(text) && ('a' <= text[size] && text[size] <= 'z') {
+				size++
+			}
+			if size == 1 {
+				return nil, 0
+			}
+			return Mention{ctx.Position(size), text[1:size]}, size
+		},
+	}, {
+		Triggers: "[",
+		Parse: func(ctx *mark.InlineContext, text string) (mark.Inline, int) {
+			if !strings.HasPrefix(text, "[[") {
+				return nil, 0
+			}
+			end := strings.Index(text, "]]")
+			if end < 0 {
+				return nil, 0
+			}
+			return WikiLink{ctx.Position(end + 2), ctx.ParseInline(text[2:end])}, end + 2
+		},
+	}},
+}
+
+func TestCustomInline(t *testing.T) {
+	TestCases{{ // mention
+		In:     "Hello @alice!",
+		Parser: customInlines,
+		Exp:    Seq(Para(Text("Hello "), Mention{Name: "alice"}, Text("!"))),
+	}, { // no match
+		In:     "Hello @ world",
+		Parser: customInlines,
+		Exp:    Seq(Para(Text("Hello @ world"))),
+	}, { // precedence over links
+		In:     "See [[*Main* page]] and [x](http://example.com)",
+		Parser: customInlines,
+		Exp: Seq(Para(
+			Text("See "), WikiLink{Page: []mark.Inline{Em(Text("Main")), Text(" page")}},
+			Text(" and "), Link("http://example.com", Text("x")),
+		)),
+	}, { // inside code span
+		In:     "`@alice`",
+		Parser: customInlines,
+		Exp:    Seq(Para(CodeSpan("@alice"))),
+	}, { // escaped
+		In:     "\\@alice",
+		Parser: customInlines,
+		Exp:    Seq(Para(Text("@alice"))),
+	}}.Run(t)
+}
+
+func TestCustomInlineHTML(t *testing.T) {
+	html.RegisterInline(Mention{}, func(inline mark.Inline) string {
+		return `<a class="mention">@` + inline.(Mention).Name + `</a>`
+	})
+
+	seq, errs := customInlines.ParseContent(nil, "main.md", []byte("Hi @bob"))
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	exp := `<p>Hi <a class="mention">@bob</a></p>`
+	if got := html.Convert(seq); got != exp {
+		t.Errorf("got %q exp %q", got, exp)
+	}
+}
+
+func BenchmarkLongParagraph(b *testing.B) {
+	for _, bench := range []struct {
+		Name    string
+		Content string
+	}{
+		{"Emphasis", strings.Repeat("word *x* word\n", 5000)},
+		{"Unmatched", strings.Repeat("*a", 10000)},
+		{"Intraword", strings.Repeat("a_", 20000)},
+		{"Text", strings.Repeat("word ", 20000)},
+	} {
+		content := []byte(bench.Content)
+		b.Run(bench.Name, func(b *testing.B) {
+			b.SetBytes(int64(len(content)))
+			for i := 0; i < b.N; i++ {
+				mark.ParseContent(mark.VirtualDir{}, "main.md", content)
+			}
+		})
+	}
+}
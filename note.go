@@ -3,12 +3,14 @@ package mark
 // Note represents a sidemark or a footnote that should not appear in the main
 // text flow.
 type Note struct {
+	Position
 	ID      string
 	Content Sequence
 }
 
 // Ref references node with a specific ID `<a class="reference" href="...">`
 type Ref struct {
+	Position
 	ID     string
 	Abbrev string
 }
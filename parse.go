@@ -1,10 +1,11 @@
 package mark
 
 import (
-	"errors"
 	"fmt"
 	"path"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
 )
 
@@ -13,114 +14,281 @@ type parse struct {
 	path   string // relative to fs root
 	reader *reader
 	*state
+	book *book
 
-	parent *parse // can be nil
+	parent  *parse // can be nil
+	virtual bool   // content is not from a file, positions refer to the parent line
 }
 
+// book contains configuration and state shared by all files of a parse
+type book struct {
+	*Parser
+	listing    bool // don't report undefined variables
+	referenced map[string]bool
+	blocks     []blockSyntax
+}
+
+// ParseError is a diagnostic at a specific location, see Pretty
+// for formatting with the source line.
 type ParseError struct {
 	Path string
 	Line int
 	Err  error
+
+	Column    int // 1-based byte column, 0 when unknown
+	EndColumn int // exclusive, 0 when the problem continues to the end of line
+	Severity  Severity
+	Code      ErrorCode
+	Source    string  // text of the line
+	Stack     []Frame // includes leading to Path, outermost first
+
+	Suppressed bool // matched a `<!-- mark-ignore -->` comment, see Parser.KeepSuppressed
+
+	Fix []Edit // edits that fix the problem, see package fix
 }
 
 func (err *ParseError) Error() string {
 	return fmt.Sprintf("%s:%d: %s", err.Path, err.Line, err.Err)
 }
 
+// Trace returns the include chain ending with the error location,
+// e.g. `book.md:3 → ch1.md:10 → snippet.md:2`.
+func (err *ParseError) Trace() string {
+	trace := ""
+	for _, frame := range err.Stack {
+		trace += frame.String() + " → "
+	}
+	return trace + Frame{err.Path, err.Line}.String()
+}
+
+func (err *ParseError) Unwrap() error { return err.Err }
+
+// Is allows checking the code with errors.Is
+func (err *ParseError) Is(target error) bool { return target == error(err.Code) }
+
+// check reports err at the current line
 func (parse *parse) check(err error) {
 	if err != nil {
-		parse.errors = append(parse.errors, &ParseError{parse.path, parse.reader.head.line, err})
+		parse.diagnose(parse.linePosition(), err)
 	}
 }
 
 type state struct {
 	sequence Sequence
 	errors   []error
+	vars     map[string]string // defined in front matter
+	variable string            // name of the variable being expanded
+
+	conditions []condBlock // open `{if}` blocks
+
+	ignores       []*ignore // `<!-- mark-ignore -->` comments
+	pendingIgnore *ignore   // applies to the next block
 
 	partial struct {
-		lines []string
-		class string
+		lines  []string
+		starts []int // offsets of lines
+		class  string
+		at     int // offset of the class modifier
 	}
 }
 
+// Parser contains options for parsing files.
+type Parser struct {
+	// Vars contains values for `{{$name}}` references. They take precedence
+	// over values defined in front matter.
+	Vars map[string]string
+
+	// Tags are active build tags for `{if tag}` blocks, e.g. "print".
+	Tags []string
+
+	// Directives are additional directives, see DefaultDirectives.
+	Directives Directives
+
+	// Inlines are custom inline syntaxes, they take precedence over
+	// the built-in syntax.
+	Inlines []InlineSyntax
+
+	// Blocks are custom block syntaxes, ordered by their priority
+	// together with the built-in ones.
+	Blocks []BlockSyntax
+
+	// KeepSuppressed keeps diagnostics suppressed by `<!-- mark-ignore -->`
+	// comments in the result with Suppressed set, instead of removing them.
+	KeepSuppressed bool
+}
+
 func ParseFile(fs FileSystem, filename string) (Sequence, []error) {
+	return (&Parser{}).ParseFile(fs, filename)
+}
+
+func ParseContent(fs FileSystem, filename string, content []byte) (Sequence, []error) {
+	return (&Parser{}).ParseContent(fs, filename, content)
+}
+
+func (parser *Parser) ParseFile(fs FileSystem, filename string) (Sequence, []error) {
 	name := filepath.ToSlash(filename)
 	data, err := fs.ReadFile(name)
 	if err != nil {
 		return nil, []error{err}
 	}
-	return ParseContent(fs, name, data)
+	return parser.ParseContent(fs, name, data)
 }
 
-func ParseContent(fs FileSystem, filename string, content []byte) (Sequence, []error) {
+func (parser *Parser) ParseContent(fs FileSystem, filename string, content []byte) (seq Sequence, errs []error) {
+	parse := parser.newParse(fs, filename, content)
+	defer func() {
+		if r := recover(); r != nil {
+			parse.internalError(r)
+			seq, errs = parse.sequence, parse.errors
+		}
+	}()
+	parse.frontMatter()
+	parse.run()
+	return parse.sequence, parse.errors
+}
+
+// ReferencedVars parses the file and returns sorted names of all
+// referenced variables, including undefined ones.
+func (parser *Parser) ReferencedVars(fs FileSystem, filename string) (names []string, errs []error) {
+	name := filepath.ToSlash(filename)
+	data, err := fs.ReadFile(name)
+	if err != nil {
+		return nil, []error{err}
+	}
+
+	parse := parser.newParse(fs, name, data)
+	parse.book.listing = true
+	defer func() {
+		if r := recover(); r != nil {
+			parse.internalError(r)
+			names, errs = nil, parse.errors
+		}
+	}()
+	parse.frontMatter()
+	parse.run()
+
+	names = []string{}
+	for name := range parse.book.referenced {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, parse.errors
+}
+
+func (parser *Parser) newParse(fs FileSystem, filename string, content []byte) *parse {
 	parse := &parse{
 		fs:     fs,
 		path:   filename,
 		state:  &state{},
 		reader: &reader{},
+		book: &book{
+			Parser:     parser,
+			referenced: map[string]bool{},
+		},
 	}
 	parse.reader.content = string(content)
-	parse.run()
-	return parse.sequence, parse.errors
+	return parse
+}
+
+// internalError records a recovered panic, e.g. from a custom syntax or
+// a directive, so that a single bad file cannot crash the caller.
+func (parse *parse) internalError(r interface{}) {
+	parse.check(ErrInternal.Errorf("Internal error: %v", r))
 }
 
 const lastlevel = 1 << 10
 
 func (parse *parse) currentSequence(level int) *Sequence {
 	seq := &parse.sequence
-	for {
-		if len(*seq) == 0 {
-			return seq
-		}
-
-		if sec, ok := (*seq)[len(*seq)-1].(*Section); ok {
-			if sec.Level >= level {
-				return seq
-			} else {
-				seq = &sec.Content
-			}
-		} else {
-			return seq
+	for len(*seq) > 0 {
+		sec, ok := (*seq)[len(*seq)-1].(*Section)
+		if !ok || sec.Level >= level {
+			break
 		}
+		seq = &sec.Content
 	}
-	panic("unreachable")
+	return seq
 }
 
 func (parse *parse) run() {
+	defer parse.applyIgnores()
+	defer parse.closeConditions()
 	defer parse.flushParagraph()
 
+	syntaxes := parse.book.blockSyntaxes()
+
 	reader := parse.reader
+next:
 	for reader.nextLine() {
 		line := reader.line()
-		switch {
-		case line.IsEmpty():
+		if line.IsEmpty() {
 			parse.flushParagraph()
-		case line.StartsWith(">"):
-			parse.quote()
-		case line.StartsWith("*** ") || line.StartsWith("--- ") || line.StartsWith("___ "):
+			continue
+		}
+		parse.beginIgnore()
+		for _, syntax := range syntaxes {
+			if syntax.start(line) {
+				syntax.parse(parse)
+				parse.endIgnore(reader.head.line)
+				continue next
+			}
+		}
+		parse.line()
+	}
+}
+
+// blockSyntax is a block parser ordered by priority
+type blockSyntax struct {
+	priority int
+	start    func(line line) bool
+	parse    func(parse *parse)
+}
+
+// blockSyntaxes returns built-in and custom block syntaxes sorted by priority
+func (book *book) blockSyntaxes() []blockSyntax {
+	if book.blocks != nil {
+		return book.blocks
+	}
+
+	book.blocks = []blockSyntax{
+		{PriorityConditional, line.StartsConditional, (*parse).conditional},
+		{PriorityIgnore, line.startsIgnore, (*parse).ignoreComment},
+		{PriorityQuote, func(line line) bool { return line.StartsWith(">") }, (*parse).quote},
+		{PrioritySeparator, func(line line) bool {
 			// TODO: handle empty item
-			parse.separator()
-		case line.StartsWith("* ") || line.StartsWith("- ") || line.StartsWith("+ "):
+			return line.StartsWith("*** ") || line.StartsWith("--- ") || line.StartsWith("___ ")
+		}, (*parse).separator},
+		{PriorityList, func(line line) bool {
 			// TODO: handle empty item
-			parse.list()
-		case line.StartsWithNumbering():
-			parse.numlist()
-		case line.StartsTitle():
-			parse.section()
-		case line.ContainsOnly('=') || line.ContainsOnly('-'):
-			parse.setext()
-		case line.StartsWith("    "):
-			parse.code()
-		case line.StartsWith("```"):
-			parse.fenced()
-		case line.StartsWith("{{"):
-			parse.include()
-		case line.StartsWith("{"):
-			parse.modifier()
-		default:
-			parse.line()
+			return line.StartsWith("* ") || line.StartsWith("- ") || line.StartsWith("+ ")
+		}, (*parse).list},
+		{PriorityNumberedList, line.StartsWithNumbering, (*parse).numlist},
+		{PriorityHeading, line.StartsTitle, (*parse).section},
+		{PrioritySetext, func(line line) bool {
+			return line.ContainsOnly('=') || line.ContainsOnly('-')
+		}, (*parse).setext},
+		{PriorityIndentedCode, func(line line) bool { return line.StartsWith("    ") }, (*parse).code},
+		{PriorityFence, line.StartsFence, (*parse).fenced},
+		{PriorityDirective, func(line line) bool { return line.StartsWith("{{") }, (*parse).directive},
+		{PriorityModifier, func(line line) bool { return line.StartsWith("{") }, (*parse).modifier},
+	}
+
+	for _, custom := range book.Blocks {
+		custom := custom
+		if custom.Start == nil || custom.Parse == nil {
+			continue
 		}
+		book.blocks = append(book.blocks, blockSyntax{
+			priority: custom.Priority,
+			start:    func(line line) bool { return custom.Start(string(line)) },
+			parse:    func(parse *parse) { parse.customBlock(custom) },
+		})
 	}
+
+	sort.SliceStable(book.blocks, func(i, k int) bool {
+		return book.blocks[i].priority < book.blocks[k].priority
+	})
+	return book.blocks
 }
 
 // flushes pending paragraph
@@ -129,19 +297,24 @@ func (parse *parse) flushParagraph() {
 		parse.partial.class = ""
 		return
 	}
+	parse.endIgnore(parse.reader.location(parse.partial.starts[len(parse.partial.starts)-1]).Line)
 
-	para := parse.linesToParagraph(parse.partial.lines)
+	para := parse.linesToParagraph(parse.partial.lines, parse.partial.starts)
 	seq := parse.currentSequence(lastlevel)
 	if parse.partial.class != "" {
+		pos := para.Position
+		pos.Start = parse.position(parse.partial.at, parse.partial.at).Start
 		seq.Append(&Modifier{
-			Class:   parse.partial.class,
-			Content: Sequence{para},
+			Position: pos,
+			Class:    parse.partial.class,
+			Content:  Sequence{para},
 		})
 	} else {
 		seq.Append(para)
 	}
 
 	parse.partial.lines = nil
+	parse.partial.starts = nil
 	parse.partial.class = ""
 }
 
@@ -149,6 +322,9 @@ func (parse *parse) separator() {
 	reader := parse.reader
 	parse.flushParagraph()
 
+	separator := &Separator{}
+	separator.Position = parse.linePosition()
+
 	delim := reader.peekRune()
 	reader.ignore(delim)
 	reader.ignore(' ')
@@ -156,7 +332,6 @@ func (parse *parse) separator() {
 	reader.ignoreTrailing(delim)
 	reader.ignoreTrailing(' ')
 
-	separator := &Separator{}
 	separator.Title = *parse.inline()
 
 	seq := parse.currentSequence(lastlevel)
@@ -165,49 +340,41 @@ func (parse *parse) separator() {
 
 func (parent *parse) quote() {
 	parent.flushParagraph()
+	start := parent.reader.head.begin
 
 	//TODO: implement lazyness
 	// http://spec.commonmark.org/0.22/#block-quotes
 
 	parent.reader.ignore(' ')
 	if !parent.reader.expect('>') {
-		panic("sanity check: " + parent.reader.rest())
-	}
-
-	child := &parse{
-		fs:     parent.fs,
-		path:   parent.path,
-		state:  &state{},
-		reader: &reader{},
-
-		parent: parent,
+		parent.check(ErrSyntax.Errorf("Expected quote, got %q", parent.reader.rest()))
+		parent.reader.resetLine()
+		parent.line()
+		return
 	}
-	*child.reader = *parent.reader
-
-	child.reader.setNextLineStart(parent.reader.head.start)
-	child.reader.prefixes = append(child.reader.prefixes, prefix{
-		symbol: '>',
-	})
-
-	child.run()
-	parent.reader.head = child.reader.head
 
-	parent.errors = append(parent.errors, child.errors...)
+	content, errs := parent.nested('>')
+	parent.errors = append(parent.errors, errs...)
 	seq := parent.currentSequence(lastlevel)
 	seq.Append(&Quote{
+		Position: parent.position(start, parent.reader.lineStop()),
 		Category: "",
 		Title:    Paragraph{},
-		Content:  child.sequence,
+		Content:  content,
 	})
 }
 
 func (parent *parse) list() {
 	parent.flushParagraph()
+	start := parent.reader.head.begin
 
 	parent.reader.ignore(' ')
 	delim := parent.reader.peekRune()
 	if !(delim == '-' || delim == '+' || delim == '*') {
-		panic("sanity check: " + parent.reader.rest())
+		parent.check(ErrSyntax.Errorf("Expected list item, got %q", parent.reader.rest()))
+		parent.reader.resetLine()
+		parent.line()
+		return
 	}
 
 	//TODO: fix
@@ -217,32 +384,16 @@ func (parent *parse) list() {
 	parent.reader.ignore(delim)
 	parent.reader.ignore(' ')
 
-	child := &parse{
-		fs:     parent.fs,
-		path:   parent.path,
-		state:  &state{},
-		reader: &reader{},
-
-		parent: parent,
-	}
-	*child.reader = *parent.reader
-
-	child.reader.setNextLineStart(parent.reader.head.start)
-	child.reader.prefixes = append(child.reader.prefixes, prefix{
-		symbol: delim,
-	})
-
-	child.run()
-	parent.reader.head = child.reader.head
-
-	parent.errors = append(parent.errors, child.errors...)
+	items, errs := parent.nested(delim)
+	parent.errors = append(parent.errors, errs...)
 
 	list := &List{
-		Ordered: false,
-		Content: nil,
+		Position: parent.position(start, parent.reader.lineStop()),
+		Ordered:  false,
+		Content:  nil,
 	}
 
-	for _, item := range child.sequence {
+	for _, item := range items {
 		list.Content = append(list.Content, Sequence{item})
 	}
 
@@ -251,9 +402,8 @@ func (parent *parse) list() {
 }
 
 func (parse *parse) numlist() {
-	parse.flushParagraph()
-	//TODO: implement
-	panic("numlist not implemented")
+	//TODO: implement, until then numbered lines are paragraph text
+	parse.line()
 }
 
 func (parse *parse) setext() {
@@ -273,12 +423,19 @@ func (parse *parse) setext() {
 	case '-':
 		section.Level = 2
 	default:
-		panic("Invalid setext header symbol " + string(x))
+		parse.check(ErrSyntax.Errorf("Invalid setext header symbol %q", x))
+		reader.resetLine()
+		parse.line()
+		return
 	}
 
-	parse.partial.lines[0] = strings.TrimSpace(parse.partial.lines[0])
-	section.Title = *parse.linesToParagraph(parse.partial.lines)
+	title := parse.partial.lines[0]
+	start := parse.partial.starts[0] + len(title) - len(strings.TrimLeft(title, " \t"))
+	title, section.ID = splitHeadingID(strings.TrimSpace(title))
+	section.Title = *parse.linesToParagraph([]string{title}, []int{start})
+	section.Position = parse.position(parse.partial.starts[0], reader.lineStop())
 	parse.partial.lines = nil
+	parse.partial.starts = nil
 
 	seq := parse.currentSequence(section.Level)
 	seq.Append(section)
@@ -287,18 +444,20 @@ func (parse *parse) setext() {
 func (parse *parse) section() {
 	reader := parse.reader
 	section := &Section{}
+	section.Position = parse.linePosition()
 
 	reader.ignoreN(' ', 3)
 	section.Level = reader.ignore('#')
 	if !order(1, section.Level, 6) {
-		parse.check(errors.New("Expected heading, but contained too many #"))
+		parse.check(ErrBadHeading.Errorf("Expected heading, but contained too many #"))
 		reader.resetLine()
 		parse.line()
 		return
 	}
 
 	if !reader.expect(' ') {
-		parse.check(errors.New("Expected space after leading #"))
+		diag := parse.diagnose(parse.linePosition(), ErrBadHeading.Errorf("Expected space after leading #"))
+		diag.Fix = parse.edit(reader.head.at, reader.head.at, " ")
 		reader.resetLine()
 		parse.line()
 		return
@@ -309,6 +468,11 @@ func (parse *parse) section() {
 	reader.ignoreSpaceTrailing('#')
 	reader.ignoreTrailing(' ')
 
+	rest := reader.rest()
+	title, id := splitHeadingID(rest)
+	reader.head.stop -= len(rest) - len(title)
+	section.ID = id
+
 	section.Title = *parse.inline()
 
 	parse.flushParagraph()
@@ -316,23 +480,44 @@ func (parse *parse) section() {
 	seq.Append(section)
 }
 
+// splitHeadingID separates explicit `{#id}` from the end of a heading
+func splitHeadingID(title string) (string, string) {
+	if !strings.HasSuffix(title, "}") {
+		return title, ""
+	}
+	start := strings.LastIndex(title, "{#")
+	if start < 0 {
+		return title, ""
+	}
+	id := title[start+2 : len(title)-1]
+	if id == "" || strings.ContainsAny(id, " {}") {
+		return title, ""
+	}
+	return strings.TrimRight(title[:start], " "), id
+}
+
 func (parse *parse) code() {
 	reader := parse.reader
 	parse.flushParagraph()
 
 	code := &Code{}
+	start := reader.head.begin
 
 	line := reader.line()
 	if !line.StartsWith("    ") {
-		panic("sanity check")
+		parse.check(ErrSyntax.Errorf("Expected indented code"))
+		parse.line()
+		return
 	}
 	code.Lines = append(code.Lines, string(line[4:]))
+	stop := reader.lineStop()
 
 	undo := false
 	for reader.nextLine() {
 		line := reader.line()
 		if line.StartsWith("    ") {
 			code.Lines = append(code.Lines, string(line[4:]))
+			stop = reader.lineStop()
 			continue
 		}
 		if line.trim3() == "" {
@@ -345,6 +530,7 @@ func (parse *parse) code() {
 	if undo {
 		reader.undoNextLine()
 	}
+	code.Position = parse.position(start, stop)
 
 	seq := parse.currentSequence(lastlevel)
 	seq.Append(code)
@@ -352,37 +538,159 @@ func (parse *parse) code() {
 
 func (parse *parse) fenced() {
 	reader := parse.reader
+	start := reader.head.begin
 
-	reader.ignore(' ')
+	indent := reader.ignoreN(' ', 3)
 
-	fencesize := reader.ignore('`')
-	fence := strings.Repeat("`", fencesize)
+	fencechar := reader.peekRune()
+	fencesize := reader.ignore(fencechar)
 
 	reader.ignore(' ')
 	reader.ignoreTrailing(' ')
 
 	code := &Code{}
-	code.Language = reader.rest()
+	code.Info = reader.rest()
+	parse.check(parseInfoString(code, code.Info))
 
 	foundend := false
+	last := reader.head.stop
 	for reader.nextLine() {
 		line := reader.line()
-		if line.StartsWith(fence) {
+		if line.IsClosingFence(fencechar, fencesize) {
 			foundend = true
 			break
 		}
-		code.Lines = append(code.Lines, string(line))
+		code.Lines = append(code.Lines, trimIndent(string(line), indent))
+		last = reader.head.stop
 	}
 
 	if !foundend {
-		parse.check(errors.New("Did not find ending code fence"))
+		diag := parse.diagnose(parse.linePosition(), ErrUnclosedFence.Errorf("Did not find ending code fence"))
+		if len(reader.prefixes) == 0 {
+			fence := strings.Repeat(" ", indent) + strings.Repeat(string(fencechar), fencesize)
+			diag.Fix = parse.edit(last, last, "\n"+fence)
+		}
 	}
+	code.Position = parse.position(start, reader.lineStop())
 
 	parse.flushParagraph()
 	seq := parse.currentSequence(lastlevel)
 	seq.Append(code)
 }
 
+// trimIndent removes up to n leading spaces
+func trimIndent(s string, n int) string {
+	for i := 0; i < n && strings.HasPrefix(s, " "); i++ {
+		s = s[1:]
+	}
+	return s
+}
+
+// parseInfoString parses fenced code info string, such as
+// `go title="main.go" {3-5,8} linenos`, into code. `linenos=10`
+// starts numbering from 10.
+func parseInfoString(code *Code, info string) error {
+	var err error
+	for i, field := range splitInfoFields(info) {
+		switch {
+		case strings.HasPrefix(field, "{") && strings.HasSuffix(field, "}"):
+			code.Highlight = append(code.Highlight, parseLineRanges(field[1:len(field)-1])...)
+		case strings.Contains(field, "="):
+			eq := strings.Index(field, "=")
+			key, value := field[:eq], unquote(field[eq+1:])
+			switch key {
+			case "title":
+				code.Title = value
+			case "linenos":
+				code.LineNumbers = true
+				start, converr := strconv.Atoi(value)
+				if converr != nil {
+					err = ErrSyntax.Errorf("Expected a number in linenos=%v", value)
+					continue
+				}
+				code.LineOffset = start - 1
+			case "lang", "language":
+				code.Language = value
+			default:
+				if code.Attrs == nil {
+					code.Attrs = map[string]string{}
+				}
+				code.Attrs[key] = value
+			}
+		case i == 0:
+			code.Language = field
+		case field == "linenos":
+			code.LineNumbers = true
+		default:
+			if code.Attrs == nil {
+				code.Attrs = map[string]string{}
+			}
+			code.Attrs[field] = ""
+		}
+	}
+	return err
+}
+
+// splitInfoFields splits info string at spaces outside of quotes and braces
+func splitInfoFields(info string) (fields []string) {
+	var quote byte
+	braces := 0
+	start := -1
+	for i := 0; i < len(info); i++ {
+		c := info[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '{':
+			braces++
+		case c == '}':
+			braces--
+		case (c == ' ' || c == '\t') && braces <= 0:
+			if start >= 0 {
+				fields = append(fields, info[start:i])
+				start = -1
+			}
+			continue
+		}
+		if start < 0 {
+			start = i
+		}
+	}
+	if start >= 0 {
+		fields = append(fields, info[start:])
+	}
+	return fields
+}
+
+// parseLineRanges parses `1,3-5`, invalid ranges are ignored
+func parseLineRanges(s string) (ranges []LineRange) {
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		from, to := part, part
+		if dash := strings.Index(part, "-"); dash >= 0 {
+			from, to = part[:dash], part[dash+1:]
+		}
+		a, erra := strconv.Atoi(strings.TrimSpace(from))
+		b, errb := strconv.Atoi(strings.TrimSpace(to))
+		if erra != nil || errb != nil || a < 1 || b < a {
+			continue
+		}
+		ranges = append(ranges, LineRange{From: a, To: b})
+	}
+	return ranges
+}
+
+func unquote(s string) string {
+	if len(s) >= 2 && (s[0] == '"' || s[0] == '\'') && s[len(s)-1] == s[0] {
+		return s[1 : len(s)-1]
+	}
+	return s
+}
+
 func (parse *parse) modifier() {
 	//TODO: figure out whether this is the best way?
 	parse.flushParagraph()
@@ -390,14 +698,27 @@ func (parse *parse) modifier() {
 
 	reader.ignoreN('{', 1)
 	reader.ignore(' ')
+
+	// `{name args}` where name is a registered directive,
+	// `{name}` without arguments is a class
+	text := strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(reader.rest()), "}"))
+	if space := strings.IndexAny(text, " \t"); space >= 0 {
+		name, args := text[:space], strings.TrimSpace(text[space:])
+		if fn, ok := parse.lookupDirective(name); ok {
+			parse.invoke(name, fn, args)
+			return
+		}
+	}
+
 	reader.ignoreN('.', 1)
 	reader.ignoreTrailingN('}', 1)
 
 	parse.partial.class = strings.TrimSpace(reader.rest())
+	parse.partial.at = reader.head.begin
 }
 
 func (parent *parse) hasPath(path string) bool {
-	for ; parent.parent != nil; parent = parent.parent {
+	for ; parent != nil; parent = parent.parent {
 		if parent.path == path {
 			return true
 		}
@@ -410,61 +731,28 @@ func (parser *parse) reltoabs(ref string) string {
 	if strings.HasPrefix(ref, "/") || !isLocalPath(ref) {
 		return ref
 	}
+	// empty or refers to the current file
+	if ref == "" || strings.HasPrefix(ref, "#") {
+		return ref
+	}
 
 	return path.Clean(path.Join(path.Dir(parser.path), ref))
 }
 
-func (parser *parse) checkPathExists(p string) {
-	if isLocalPath(p) {
-		if parser.fs == nil {
-			parser.check(fmt.Errorf("Cannot find file %s", p))
-		} else if err := parser.fs.FileExists(p); err != nil {
-			parser.check(fmt.Errorf("Cannot find file %s: %s", p, err))
-		}
-	}
-}
-
-func (parent *parse) include() {
-	parentreader := parent.reader
-	parent.flushParagraph()
-
-	parentreader.ignoreN('{', 2)
-	parentreader.ignoreTrailingN('}', 2)
-
-	file := strings.TrimSpace(parentreader.rest())
-	abs := parent.reltoabs(file)
-
-	child := &parse{
-		fs:     parent.fs,
-		path:   abs,
-		state:  &state{},
-		reader: &reader{},
-
-		parent: parent,
-	}
-
-	if parent.hasPath(abs) {
-		parent.check(fmt.Errorf("Cannot recursively include %v", abs))
+// checkPathExists warns about a missing link target at pos
+func (parser *parse) checkPathExists(p string, pos Position) {
+	if !isLocalPath(p) || p == "" || strings.HasPrefix(p, "#") {
 		return
 	}
-
-	content, err := child.fs.ReadFile(abs)
-	if err != nil {
-		parent.check(fmt.Errorf("Failed to read file %v: %v", abs, err))
-		return
+	var err error
+	if parser.fs == nil {
+		err = ErrMissingFile.Errorf("Cannot find file %s", p)
+	} else if ferr := parser.fs.FileExists(p); ferr != nil {
+		err = ErrMissingFile.Errorf("Cannot find file %s: %s", p, ferr)
 	}
-
-	child.reader.content = string(content)
-	child.run()
-
-	seq := parent.currentSequence(lastlevel)
-	for _, block := range child.sequence {
-		if sec, ok := block.(*Section); ok {
-			seq = parent.currentSequence(sec.Level)
-		}
-		seq.Append(block)
+	if err != nil {
+		parser.diagnose(pos, err).Severity = SeverityWarning
 	}
-	parent.errors = append(child.errors)
 }
 
 func (parse *parse) line() {
@@ -472,11 +760,12 @@ func (parse *parse) line() {
 
 	reader.ignore(' ')
 	parse.partial.lines = append(parse.partial.lines, reader.rest())
+	parse.partial.starts = append(parse.partial.starts, reader.head.at)
 }
 
 func (parse *parse) inline() *Paragraph {
 	reader := parse.reader
-	return parse.linesToParagraph([]string{reader.rest()})
+	return parse.linesToParagraph([]string{reader.rest()}, []int{reader.head.at})
 }
 
 func order(xs ...int) bool {
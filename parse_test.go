@@ -1,6 +1,9 @@
 package mark_test
 
 import (
+	"errors"
+	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/loov/mark"
@@ -36,8 +39,12 @@ func TestSection(t *testing.T) {
 		In:  "### Hello\nWorld",
 		Exp: Seq(H(3, Para(Text("Hello")), Para(Text("World")))),
 	}, { // require space
-		In:  "###Hello\nWorld",
-		Exp: Seq(Para(Text("###Hello"), SB, Text("World"))),
+		In:   "###Hello\nWorld",
+		Exp:  Seq(Para(Text("###Hello"), SB, Text("World"))),
+		Errs: []string{"main.md:1: Expected space after leading #"},
+	}, { // hashtag
+		In:  "#Hello\nWorld",
+		Exp: Seq(Para(Text("#Hello"), SB, Text("World"))),
 	}, { // too many ###
 		In:   "######## Hello",
 		Exp:  Seq(Para(Text("######## Hello"))),
@@ -223,3 +230,724 @@ func TestInclude(t *testing.T) {
 		Errs: []string{"include2.md:1: Cannot recursively include include.md"},
 	}}.Run(t)
 }
+
+const includeCodeSource = `package pkg
+
+// Hello greets.
+func Hello() string {
+	return "hello"
+}
+
+type (
+	// Greeter greets people.
+	Greeter struct{ Name string }
+)
+
+// Greet greets g.
+func (g *Greeter) Greet() string { return g.Name }
+
+func ExampleHello() {
+	Hello()
+}
+`
+
+func TestIncludeCode(t *testing.T) {
+	TestCases{{ // function
+		In: "{{code pkg/hello.go:Hello}}",
+		FS: mark.VirtualDir{"pkg/hello.go": includeCodeSource},
+		Exp: Seq(Code("go",
+			"// Hello greets.",
+			"func Hello() string {",
+			"\treturn \"hello\"",
+			"}",
+		)),
+	}, { // method
+		In:  "{{code pkg/hello.go:Greeter.Greet}}",
+		FS:  mark.VirtualDir{"pkg/hello.go": includeCodeSource},
+		Exp: Seq(Code("go", "// Greet greets g.", "func (g *Greeter) Greet() string { return g.Name }")),
+	}, { // type in a group
+		In:  "{{code pkg/hello.go:Greeter}}",
+		FS:  mark.VirtualDir{"pkg/hello.go": includeCodeSource},
+		Exp: Seq(Code("go", "// Greeter greets people.", "type Greeter struct{ Name string }")),
+	}, { // example
+		In:  "{{code pkg/hello.go:ExampleHello}}",
+		FS:  mark.VirtualDir{"pkg/hello.go": includeCodeSource},
+		Exp: Seq(Code("go", "func ExampleHello() {", "\tHello()", "}")),
+	}, { // missing symbol
+		In:   "{{code pkg/hello.go:Goodbye}}",
+		FS:   mark.VirtualDir{"pkg/hello.go": includeCodeSource},
+		Errs: []string{"main.md:1: Cannot find declaration Goodbye in pkg/hello.go"},
+	}}.Run(t)
+}
+
+func TestIncludeGlob(t *testing.T) {
+	TestCases{{ // glob
+		In: "{{chapters/*.md}}",
+		FS: mark.VirtualDir{
+			"chapters/b.md":     "B",
+			"chapters/a.md":     "A",
+			"chapters/c.txt":    "C",
+			"chapters/sub/d.md": "D",
+		},
+		Exp: Seq(Para(Text("A")), Para(Text("B"))),
+	}, { // directory
+		In: "{{chapters/}}",
+		FS: mark.VirtualDir{
+			"chapters/10-end.md":  "End",
+			"chapters/2-intro.md": "Intro",
+		},
+		Exp: Seq(Para(Text("End")), Para(Text("Intro"))),
+	}, { // numeric order
+		In: "{{chapters/ order=numeric}}",
+		FS: mark.VirtualDir{
+			"chapters/10-end.md":  "End",
+			"chapters/2-intro.md": "Intro",
+		},
+		Exp: Seq(Para(Text("Intro")), Para(Text("End"))),
+	}, { // glob in directory names, relative links
+		In: "{{parts/*/index.md}}",
+		FS: mark.VirtualDir{
+			"parts/one/index.md": "{{one.md}}",
+			"parts/one/one.md":   "One",
+			"parts/two/index.md": "{{two.md}}",
+			"parts/two/two.md":   "Two",
+		},
+		Exp: Seq(Para(Text("One")), Para(Text("Two"))),
+	}, { // no matches
+		In:   "{{chapters/*.md}}",
+		FS:   mark.VirtualDir{"chapters/a.txt": "A"},
+		Errs: []string{"main.md:1: No files match chapters/*.md"},
+	}}.Run(t)
+}
+
+func TestIncludeLevel(t *testing.T) {
+	TestCases{{ // relative shift
+		In: "# Part\n{{chapter.md level=+1}}",
+		FS: mark.VirtualDir{
+			"chapter.md": "# Chapter\n## Section",
+		},
+		Exp: Seq(
+			H(1, Para(Text("Part")),
+				H(2, Para(Text("Chapter")),
+					H(3, Para(Text("Section"))))),
+		),
+	}, { // absolute level
+		In: "{{chapter.md level=3}}",
+		FS: mark.VirtualDir{
+			"chapter.md": "## Chapter",
+		},
+		Exp: Seq(H(3, Para(Text("Chapter")))),
+	}, { // nest under current section
+		In: "# Part\n## Subpart\n{{chapter.md level=nest}}",
+		FS: mark.VirtualDir{
+			"chapter.md": "# Chapter",
+		},
+		Exp: Seq(
+			H(1, Para(Text("Part")),
+				H(2, Para(Text("Subpart")),
+					H(3, Para(Text("Chapter"))))),
+		),
+	}, { // nest all files under the include site
+		In: "# Part\n{{chapters/*.md level=nest}}",
+		FS: mark.VirtualDir{
+			"chapters/a.md": "# A",
+			"chapters/b.md": "# B",
+		},
+		Exp: Seq(
+			H(1, Para(Text("Part")),
+				H(2, Para(Text("A"))),
+				H(2, Para(Text("B")))),
+		),
+	}, { // shift past 6
+		In: "{{chapter.md level=+5}}",
+		FS: mark.VirtualDir{
+			"chapter.md": "## Chapter",
+		},
+		Exp:  Seq(H(6, Para(Text("Chapter")))),
+		Errs: []string{"main.md:1: Shifting headings by +5 results in level 7"},
+	}}.Run(t)
+}
+
+func TestIncludeSection(t *testing.T) {
+	shared := mark.VirtualDir{
+		"shared.md": "# Shared\n## Installation\nInstall\n### Linux\nApt\n## Usage {#use}\nUse",
+	}
+	TestCases{{ // by slug
+		In: "# Book\n{{shared.md#installation}}",
+		FS: shared,
+		Exp: Seq(
+			H(1, Para(Text("Book")),
+				H(2, Para(Text("Installation")), Para(Text("Install")),
+					H(3, Para(Text("Linux")), Para(Text("Apt"))))),
+		),
+	}, { // by id
+		In: "{{shared.md#use level=1}}",
+		FS: shared,
+		Exp: Seq(&mark.Section{
+			Level:   1,
+			ID:      "use",
+			Title:   *Para(Text("Usage")),
+			Content: Seq(Para(Text("Use"))),
+		}),
+	}, { // an argument named # does not select a section
+		In: "{{shared.md #=installation}}",
+		FS: shared,
+		Exp: Seq(
+			H(1, Para(Text("Shared")),
+				H(2, Para(Text("Installation")), Para(Text("Install")),
+					H(3, Para(Text("Linux")), Para(Text("Apt")))),
+				&mark.Section{
+					Level:   2,
+					ID:      "use",
+					Title:   *Para(Text("Usage")),
+					Content: Seq(Para(Text("Use"))),
+				}),
+		),
+	}, { // missing section
+		In:   "{{shared.md#removal}}",
+		FS:   shared,
+		Errs: []string{`main.md:1: Cannot find section "removal" in shared.md, available: shared, installation, linux, use`},
+	}}.Run(t)
+}
+
+func TestVariables(t *testing.T) {
+	parser := &mark.Parser{
+		Vars: map[string]string{"version": "1.2", "intro": "# Intro\nHello"},
+	}
+	TestCases{{ // inline
+		In:     "Version {{$version}} is *out*",
+		Parser: parser,
+		Exp:    Seq(Para(Text("Version 1.2 is "), Em(Text("out")))),
+	}, { // at line start and in code span
+		In:     "{{$version}} and `v{{$version}}`",
+		Parser: parser,
+		Exp:    Seq(Para(Text("1.2 and "), CodeSpan("v1.2"))),
+	}, { // link destination and title
+		In:     "[notes](notes-{{$version}}.md \"v{{$version}}\")",
+		Parser: parser,
+		FS:     mark.VirtualDir{"notes-1.2.md": ""},
+		Exp:    Seq(Para(Tooltip(Link("notes-1.2.md", Text("notes")), "v1.2"))),
+	}, { // not in code blocks
+		In:     "```\n{{$version}}\n```",
+		Parser: parser,
+		Exp:    Seq(Code("", "{{$version}}")),
+	}, { // block
+		In:     "{{$intro}}",
+		Parser: parser,
+		Exp:    Seq(H(1, Para(Text("Intro")), Para(Text("Hello")))),
+	}, { // front matter
+		In:  "---\nname: \"Book\"\n---\n# {{$name}}",
+		Exp: Seq(H(1, Para(Text("Book")))),
+	}, { // front matter in included file
+		In: "{{chapter.md}}",
+		FS: mark.VirtualDir{
+			"chapter.md": "---\nname: Chapter\n---\n{{$name}}",
+		},
+		Exp: Seq(Para(Text("Chapter"))),
+	}, { // no closing fence
+		In:  "---\n\nHello world\n\nmore text\n",
+		Exp: Seq(Para(Text("---")), Para(Text("Hello world")), Para(Text("more text"))),
+	}, { // not front matter
+		In:  "---\nHello: world\nmore text\n---\n",
+		Exp: Seq(Para(Text("---"), SB, Text("Hello: world"), SB, Text("more text"), SB, Text("---"))),
+	}, { // escaped
+		In:  "\\{{$version}}",
+		Exp: Seq(Para(Text("{{$version}}"))),
+	}, { // undefined
+		In:   "Version {{$version}}",
+		Exp:  Seq(Para(Text("Version {{$version}}"))),
+		Errs: []string{"main.md:1: Undefined variable version"},
+	}}.Run(t)
+}
+
+func TestReferencedVars(t *testing.T) {
+	fs := mark.VirtualDir{
+		"main.md":    "{{$title}}\n{{chapter.md}}",
+		"chapter.md": "Version {{$version}} of {{$title}}",
+	}
+	names, errs := (&mark.Parser{}).ReferencedVars(fs, "main.md")
+	if len(errs) > 0 {
+		t.Errorf("unexpected errors: %v", errs)
+	}
+	if !reflect.DeepEqual(names, []string{"title", "version"}) {
+		t.Errorf("got %v", names)
+	}
+}
+
+func TestConditional(t *testing.T) {
+	print := &mark.Parser{Tags: []string{"print"}}
+	web := &mark.Parser{Tags: []string{"web", "internal"}}
+	TestCases{{ // active
+		In:     "A\n{if print}\nB\n{end}\nC",
+		Parser: print,
+		Exp:    Seq(Para(Text("A")), Para(Text("B")), Para(Text("C"))),
+	}, { // inactive
+		In:     "A\n{if print}\nB\n{end}\nC",
+		Parser: web,
+		Exp:    Seq(Para(Text("A")), Para(Text("C"))),
+	}, { // else
+		In:     "{if print}\nB\n{else}\nC\n{end}",
+		Parser: web,
+		Exp:    Seq(Para(Text("C"))),
+	}, { // else skipped
+		In:     "{if print}\nB\n{else}\nC\n{end}",
+		Parser: print,
+		Exp:    Seq(Para(Text("B"))),
+	}, { // boolean combinations
+		In:     "{if (print || web) && !internal}\nA\n{end}\n{if web && internal}\nB\n{end}",
+		Parser: web,
+		Exp:    Seq(Para(Text("B"))),
+	}, { // nested in skipped block
+		In:     "{if print}\n{if web}\nA\n{end}\nB\n{end}\nC",
+		Parser: web,
+		Exp:    Seq(Para(Text("C"))),
+	}, { // fenced code in skipped block
+		In:     "{if print}\n```\n{end}\n```\n{end}\nC",
+		Parser: web,
+		Exp:    Seq(Para(Text("C"))),
+	}, { // indented code is not a directive
+		In:     "{if print}\nA\n\n    {end}\n{end}",
+		Parser: print,
+		Exp:    Seq(Para(Text("A")), Code("", "{end}")),
+	}, { // duplicate else in active block
+		In:     "{if print}\nA\n{else}\nB\n{else}\nC\n{end}\nD",
+		Parser: print,
+		Exp:    Seq(Para(Text("A")), Para(Text("D"))),
+		Errs:   []string{"main.md:5: Duplicate {else} for {if print}"},
+	}, { // duplicate else in else block
+		In:     "{if print}\nA\n{else}\nB\n{else}\nC\n{end}\nD",
+		Parser: web,
+		Exp:    Seq(Para(Text("B")), Para(Text("D"))),
+		Errs:   []string{"main.md:5: Duplicate {else} for {if print}"},
+	}, { // unclosed
+		In:   "{if print}\nA",
+		Errs: []string{"main.md:2: Did not find {end}"},
+	}, { // unexpected end
+		In:   "A\n{end}",
+		Exp:  Seq(Para(Text("A"))),
+		Errs: []string{"main.md:2: Unexpected {end} without {if}"},
+	}, { // invalid condition
+		In:   "{if print &&}\nA\n{end}",
+		Errs: []string{`main.md:1: Invalid condition "print &&": unexpected end of condition`},
+	}}.Run(t)
+}
+
+func TestDirective(t *testing.T) {
+	parser := &mark.Parser{
+		Directives: mark.Directives{
+			"note": func(ctx *mark.DirectiveContext, args string) ([]mark.Block, []error) {
+				content, errs := ctx.ParseContent(args)
+				return []mark.Block{&mark.Modifier{Class: "note", Content: content}}, errs
+			},
+			"fail": func(ctx *mark.DirectiveContext, args string) ([]mark.Block, []error) {
+				return nil, []error{errors.New("failed " + args + " in " + ctx.Path)}
+			},
+		},
+	}
+	TestCases{{ // custom directive
+		In:     "{{note *Hello*}}",
+		Parser: parser,
+		Exp:    Seq(&mark.Modifier{Class: "note", Content: Seq(Para(Em(Text("Hello"))))}),
+	}, { // single brace directive
+		In:     "A\n{note B}",
+		Parser: parser,
+		Exp: Seq(
+			Para(Text("A")),
+			&mark.Modifier{Class: "note", Content: Seq(Para(Text("B")))},
+		),
+	}, { // errors
+		In:     "A\n\n{{fail X}}",
+		Parser: parser,
+		Exp:    Seq(Para(Text("A"))),
+		Errs:   []string{"main.md:3: failed X in main.md"},
+	}, { // explicit include
+		In:     "{{include include.md}}",
+		Parser: parser,
+		FS:     mark.VirtualDir{"include.md": "Content"},
+		Exp:    Seq(Para(Text("Content"))),
+	}, { // unregistered names are paths
+		In:     "{{note.md}}",
+		Parser: parser,
+		FS:     mark.VirtualDir{"note.md": "Content"},
+		Exp:    Seq(Para(Text("Content"))),
+	}, { // classes are not affected
+		In:     "{.note}\nA",
+		Parser: parser,
+		Exp:    Seq(&mark.Modifier{Class: "note", Content: Seq(Para(Text("A")))}),
+	}, { // directive names without arguments are classes
+		In:  "{code}\nhello",
+		Exp: Seq(&mark.Modifier{Class: "code", Content: Seq(Para(Text("hello")))}),
+	}, {
+		In:     "{note}\nA",
+		Parser: parser,
+		Exp:    Seq(&mark.Modifier{Class: "note", Content: Seq(Para(Text("A")))}),
+	}}.Run(t)
+}
+
+func TestCustomBlock(t *testing.T) {
+	parser := &mark.Parser{
+		Blocks: []mark.BlockSyntax{{ // fenced div
+			Priority: mark.PriorityFence,
+			Start:    func(line string) bool { return strings.HasPrefix(line, ":::") },
+			Parse: func(ctx *mark.BlockContext) ([]mark.Block, []error) {
+				class := strings.TrimSpace(strings.TrimPrefix(ctx.Line(), ":::"))
+				var lines []string
+				for ctx.Next() {
+					if strings.TrimSpace(ctx.Line()) == ":::" {
+						content, errs := ctx.ParseLines(lines)
+						return []mark.Block{&mark.Modifier{Class: class, Content: content}}, errs
+					}
+					lines = append(lines, ctx.Line())
+				}
+				return nil, []error{errors.New("Did not find closing :::")}
+			},
+		}, { // prefixed aside
+			Priority: mark.PriorityQuote,
+			Start:    func(line string) bool { return strings.HasPrefix(line, "%") },
+			Parse: func(ctx *mark.BlockContext) ([]mark.Block, []error) {
+				content, errs := ctx.Nested('%')
+				return []mark.Block{&mark.Modifier{Class: "aside", Content: content}}, errs
+			},
+		}, { // before built-in lists
+			Priority: mark.PriorityList - 1,
+			Start:    func(line string) bool { return strings.HasPrefix(line, "+ ") },
+			Parse: func(ctx *mark.BlockContext) ([]mark.Block, []error) {
+				return []mark.Block{&mark.Modifier{Class: "plus"}}, nil
+			},
+		}, { // single line, continues until a non-matching line
+			Priority: mark.PriorityModifier + 1,
+			Start:    func(line string) bool { return strings.HasPrefix(line, "|") },
+			Parse: func(ctx *mark.BlockContext) ([]mark.Block, []error) {
+				code := &mark.Code{Language: "table"}
+				code.Lines = append(code.Lines, ctx.Line())
+				for ctx.Next() {
+					if !strings.HasPrefix(ctx.Line(), "|") {
+						ctx.Unread()
+						break
+					}
+					code.Lines = append(code.Lines, ctx.Line())
+				}
+				return []mark.Block{code}, nil
+			},
+		}},
+	}
+	TestCases{{ // fenced div with nested markdown
+		In:     "A\n::: warning\n# Title\n*B*\n:::\nC",
+		Parser: parser,
+		Exp: Seq(
+			Para(Text("A")),
+			&mark.Modifier{Class: "warning", Content: Seq(H(1, Para(Text("Title")), Para(Em(Text("B")))))},
+			Para(Text("C")),
+		),
+	}, { // unclosed
+		In:     "::: warning\nA",
+		Parser: parser,
+		Errs:   []string{"main.md:2: Did not find closing :::"},
+	}, { // nested with prefix
+		In:     "% A\n% B\n\nC",
+		Parser: parser,
+		Exp: Seq(
+			&mark.Modifier{Class: "aside", Content: Seq(Para(Text("A"), SB, Text("B")))},
+			Para(Text("C")),
+		),
+	}, { // inside quote
+		In:     "> % A",
+		Parser: parser,
+		Exp:    Seq(Quote(&mark.Modifier{Class: "aside", Content: Seq(Para(Text("A")))})),
+	}, { // priority
+		In:     "+ A",
+		Parser: parser,
+		Exp:    Seq(&mark.Modifier{Class: "plus"}),
+	}, { // unread
+		In:     "|a|\n|b|\nC",
+		Parser: parser,
+		Exp:    Seq(&mark.Code{Language: "table", Lines: []string{"|a|", "|b|"}}, Para(Text("C"))),
+	}}.Run(t)
+}
+
+func TestFenceInfo(t *testing.T) {
+	TestCases{{ // tildes
+		In:  "~~~ go\n```\n~~~",
+		Exp: Seq(Code("go", "```")),
+	}, { // closing fence must be at least as long
+		In:  "````\n```\nA\n`````",
+		Exp: Seq(Code("", "```", "A")),
+	}, { // closing fence cannot have info
+		In:  "```\n``` go\n```",
+		Exp: Seq(Code("", "``` go")),
+	}, { // indentation is stripped
+		In:  "  ```\n  A\n B\n    C\n  ```",
+		Exp: Seq(Code("", "A", "B", "  C")),
+	}, { // backticks in info string is not a fence
+		In:  "``` a`b",
+		Exp: Seq(Para(Text("``` a`b"))),
+	}, { // metadata
+		In: "```go title=\"main.go\" {3-5,8} linenos data-x=y hidden\nA\n```",
+		Exp: Seq(&mark.Code{
+			Language:    "go",
+			Info:        "go title=\"main.go\" {3-5,8} linenos data-x=y hidden",
+			Title:       "main.go",
+			Highlight:   []mark.LineRange{{From: 3, To: 5}, {From: 8, To: 8}},
+			LineNumbers: true,
+			Attrs:       map[string]string{"data-x": "y", "hidden": ""},
+			Lines:       []string{"A"},
+		}),
+	}, { // quoted title with spaces
+		In: "~~~ {1} title='Hello world'\nA\n~~~",
+		Exp: Seq(&mark.Code{
+			Info:      "{1} title='Hello world'",
+			Title:     "Hello world",
+			Highlight: []mark.LineRange{{From: 1, To: 1}},
+			Lines:     []string{"A"},
+		}),
+	}, { // invalid line start
+		In: "``` linenos=abc\nA\n```",
+		Exp: Seq(&mark.Code{
+			Info:        "linenos=abc",
+			LineNumbers: true,
+			Lines:       []string{"A"},
+		}),
+		Errs: []string{"main.md:1: Expected a number in linenos=abc"},
+	}}.Run(t)
+}
+
+func TestRecoverable(t *testing.T) {
+	TestCases{{ // numbered list
+		In:  "1. one\n2. two",
+		Exp: Seq(Para(Text("1. one"), SB, Text("2. two"))),
+	}, { // panicking custom syntax
+		In: "A\n%%\nB",
+		Parser: &mark.Parser{
+			Blocks: []mark.BlockSyntax{{
+				Start: func(line string) bool { return line == "%%" },
+				Parse: func(ctx *mark.BlockContext) ([]mark.Block, []error) { panic("boom") },
+			}},
+		},
+		Exp:  Seq(Para(Text("A"))),
+		Errs: []string{"main.md:2: Internal error: boom"},
+	}}.Run(t)
+}
+
+var fuzzSeeds = []string{
+	"# Title {#id}\nSetext\n===\n\nPara *em* **bold** `code` [link](<a b> \"t\")",
+	"> quote\n> * item\n> - item\n\n1. first\n2. second",
+	"```go {1-2} linenos title=x\nfunc main() {}\n```\n\n    indented\n",
+	"{{include a.md}}\n{{include *.md}}\n{{include a.md#intro level=+1}}\n{{$name}}",
+	"---\nname: value\n---\n{if print && !web}\nA\n{else}\nB\n{end}\n{warning}\n![i](i.png)",
+	"***\n---\n___ title\n- \n*\n>\n#\n####### x\n=\n-",
+}
+
+func FuzzParseContent(f *testing.F) {
+	for _, seed := range fuzzSeeds {
+		f.Add(seed)
+	}
+	fs := mark.VirtualDir{
+		"a.md": "# Intro\nHello {{$name}}\n{{include a.md}}",
+		"b.md": "* b\n{{include missing.md}}",
+	}
+	f.Fuzz(func(t *testing.T, content string) {
+		_, errs := mark.ParseContent(fs, "main.md", []byte(content))
+		for _, err := range errs {
+			var perr *mark.ParseError
+			if !errors.As(err, &perr) {
+				t.Errorf("%q: error %q is not a ParseError", content, err)
+			}
+			if strings.Contains(err.Error(), "Internal error") {
+				t.Errorf("%q: %v", content, err)
+			}
+		}
+	})
+}
+
+func TestPositions(t *testing.T) {
+	fs := mark.VirtualDir{"b.md": "## Included"}
+	content := "# Title\n\nHello *world* and\n[`x`](b.md)\n\n> quote\n\n{{include b.md}}"
+	seq, errs := mark.ParseContent(fs, "main.md", []byte(content))
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+
+	pos := func(path string, line, col, offset, endline, endcol, endoffset int) mark.Position {
+		return mark.Position{
+			Path:  path,
+			Start: mark.Location{Line: line, Column: col, Offset: offset},
+			End:   mark.Location{Line: endline, Column: endcol, Offset: endoffset},
+		}
+	}
+
+	title := seq[0].(*mark.Section)
+	para := title.Content[0].(*mark.Paragraph)
+	link := para.Items[4].(mark.Link)
+	quote := title.Content[1].(*mark.Quote)
+	included := title.Content[2].(*mark.Section)
+	includedPos, includedBy := included.Pos(), included.IncludedBy
+	if includedBy == nil || includedBy.Text != "{{include b.md}}" {
+		t.Fatalf("included by %+v", includedBy)
+	}
+	includedPos.IncludedBy = nil
+
+	tests := []struct {
+		Name string
+		Got  mark.Position
+		Exp  mark.Position
+	}{
+		{"section", title.Pos(), pos("main.md", 1, 1, 0, 1, 8, 7)},
+		{"title", title.Title.Pos(), pos("main.md", 1, 3, 2, 1, 8, 7)},
+		{"paragraph", para.Pos(), pos("main.md", 3, 1, 9, 4, 12, 38)},
+		{"emphasis", para.Items[1].Pos(), pos("main.md", 3, 7, 15, 3, 14, 22)},
+		{"soft break", para.Items[3].Pos(), pos("main.md", 3, 18, 26, 4, 1, 27)},
+		{"link", link.Pos(), pos("main.md", 4, 1, 27, 4, 12, 38)},
+		{"code span", link.Title.Items[0].Pos(), pos("main.md", 4, 2, 28, 4, 5, 31)},
+		{"quote", quote.Pos(), pos("main.md", 6, 1, 40, 6, 8, 47)},
+		{"included", includedPos, pos("b.md", 1, 1, 0, 1, 12, 11)},
+		{"include line", includedBy.Position, pos("main.md", 8, 1, 49, 8, 17, 65)},
+	}
+	for _, test := range tests {
+		if test.Got != test.Exp {
+			t.Errorf("%s: got %v %+v %+v exp %v %+v %+v", test.Name,
+				test.Got.Path, test.Got.Start, test.Got.End,
+				test.Exp.Path, test.Exp.Start, test.Exp.End)
+		}
+	}
+}
+
+func TestDiagnostics(t *testing.T) {
+	content := "# Title\n\nSee [x](missing.md) and {{$v}}.\n\n```go\nA"
+	_, errs := mark.ParseContent(mark.VirtualDir{}, "main.md", []byte(content))
+	if len(errs) != 3 {
+		t.Fatalf("expected 3 errors, got %v", errs)
+	}
+
+	tests := []struct {
+		Code     mark.ErrorCode
+		Severity mark.Severity
+		Line     int
+		Column   int
+		Pretty   string
+	}{{
+		Code:     mark.ErrUndefinedVariable,
+		Severity: mark.SeverityError,
+		Line:     3, Column: 25,
+		Pretty: "main.md:3:25: error: Undefined variable v [undefined-variable]\n" +
+			"\tSee [x](missing.md) and {{$v}}.\n" +
+			"\t                        ^^^^^^",
+	}, {
+		Code:     mark.ErrMissingFile,
+		Severity: mark.SeverityWarning,
+		Line:     3, Column: 9,
+		Pretty: "main.md:3:9: warning: Cannot find file missing.md: file does not exist [missing-file]\n" +
+			"\tSee [x](missing.md) and {{$v}}.\n" +
+			"\t        ^^^^^^^^^^",
+	}, {
+		Code:     mark.ErrUnclosedFence,
+		Severity: mark.SeverityWarning,
+		Line:     6, Column: 1,
+		Pretty: "main.md:6:1: warning: Did not find ending code fence [unclosed-fence]\n" +
+			"\tA\n" +
+			"\t^",
+	}}
+
+	for i, test := range tests {
+		var diag *mark.ParseError
+		if !errors.As(errs[i], &diag) {
+			t.Errorf("#%d: expected ParseError, got %T", i, errs[i])
+			continue
+		}
+		if diag.Code != test.Code || diag.Severity != test.Severity || diag.Line != test.Line || diag.Column != test.Column {
+			t.Errorf("#%d: got %v %v %d:%d exp %v %v %d:%d", i,
+				diag.Code, diag.Severity, diag.Line, diag.Column,
+				test.Code, test.Severity, test.Line, test.Column)
+		}
+		if !errors.Is(errs[i], test.Code) {
+			t.Errorf("#%d: expected errors.Is(err, %v)", i, test.Code)
+		}
+		if got := mark.Pretty(errs[i]); got != test.Pretty {
+			t.Errorf("#%d: got\n%s\nexp\n%s", i, got, test.Pretty)
+		}
+	}
+}
+
+func TestIncludeTrace(t *testing.T) {
+	fs := mark.VirtualDir{
+		"book.md":    "{{$missing}}\n\n{{include ch1.md}}\n\n{{$other}}",
+		"ch1.md":     "# Chapter\n\n{{include snippet.md}}",
+		"snippet.md": "Text\n{{include nothing.md}}",
+	}
+	_, errs := mark.ParseFile(fs, "book.md")
+
+	exp := []struct{ Error, Trace string }{
+		{"book.md:1: Undefined variable missing", "book.md:1"},
+		{"snippet.md:2: Failed to read file nothing.md: file does not exist", "book.md:3 → ch1.md:3 → snippet.md:2"},
+		{"book.md:5: Undefined variable other", "book.md:5"},
+	}
+	if len(errs) != len(exp) {
+		t.Fatalf("got %v exp %v", errs, exp)
+	}
+	for i, err := range errs {
+		var diag *mark.ParseError
+		if !errors.As(err, &diag) {
+			t.Fatalf("#%d: expected ParseError, got %T", i, err)
+		}
+		if diag.Error() != exp[i].Error || diag.Trace() != exp[i].Trace {
+			t.Errorf("#%d: got %q %q exp %q %q", i, diag.Error(), diag.Trace(), exp[i].Error, exp[i].Trace)
+		}
+	}
+}
+
+func TestIgnore(t *testing.T) {
+	fs := mark.VirtualDir{
+		"book.md": "<!-- mark-ignore-file undefined-variable -->\n" +
+			"{{$a}}\n\n" +
+			"<!-- mark-ignore missing-file -->\n" +
+			"See [x](x.md)\n" +
+			"and [y](y.md).\n\n" +
+			"See [z](z.md) and {{$b}}.\n\n" +
+			"<!-- mark-ignore -->\n" +
+			"{{include ch1.md}}\n\n" +
+			"{{include ch2.md}}",
+		"ch1.md": "{{$c}} [w](w.md)",
+		"ch2.md": "<!-- mark-ignore missing-file -->\n[v](v.md)\n\n[u](u.md) {{$d}}",
+	}
+
+	tests := []struct {
+		Keep bool
+		Exp  []string
+	}{{
+		Keep: false,
+		Exp: []string{
+			"book.md:8: Cannot find file z.md: file does not exist",
+			"ch2.md:4: Cannot find file u.md: file does not exist",
+		},
+	}, {
+		Keep: true,
+		Exp: []string{
+			"book.md:2: Undefined variable a (suppressed)",
+			"book.md:5: Cannot find file x.md: file does not exist (suppressed)",
+			"book.md:6: Cannot find file y.md: file does not exist (suppressed)",
+			"book.md:8: Undefined variable b (suppressed)",
+			"book.md:8: Cannot find file z.md: file does not exist",
+			"ch1.md:1: Undefined variable c (suppressed)",
+			"ch1.md:1: Cannot find file w.md: file does not exist (suppressed)",
+			"ch2.md:2: Cannot find file v.md: file does not exist (suppressed)",
+			"ch2.md:4: Undefined variable d (suppressed)",
+			"ch2.md:4: Cannot find file u.md: file does not exist",
+		},
+	}}
+
+	for _, test := range tests {
+		parser := &mark.Parser{KeepSuppressed: test.Keep}
+		_, errs := parser.ParseFile(fs, "book.md")
+
+		got := []string{}
+		for _, err := range errs {
+			s := err.Error()
+			if diag, ok := err.(*mark.ParseError); ok && diag.Suppressed {
+				s += " (suppressed)"
+			}
+			got = append(got, s)
+		}
+		if strings.Join(got, "\n") != strings.Join(test.Exp, "\n") {
+			t.Errorf("keep=%v: got\n%s\nexp\n%s", test.Keep, strings.Join(got, "\n"), strings.Join(test.Exp, "\n"))
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package mark
+
+import "strings"
+
+// Pass transforms a parsed book, e.g. shifts heading levels or
+// rewrites links. The sequence may be modified in place.
+type Pass func(seq Sequence) (Sequence, []error)
+
+// Pipeline parses files and runs passes on the result in order,
+// before converting it to the output format:
+//
+//	pipeline := &mark.Pipeline{
+//		Passes: []mark.Pass{mark.ShiftHeadings(1), stripDrafts},
+//	}
+//	seq, errs := pipeline.ParseFile(fs, "book.md")
+//	out := html.Convert(seq)
+type Pipeline struct {
+	Parser *Parser // nil uses the default options
+	Passes []Pass
+}
+
+// ParseFile parses filename and runs the passes
+func (pipeline *Pipeline) ParseFile(fs FileSystem, filename string) (Sequence, []error) {
+	parser := pipeline.Parser
+	if parser == nil {
+		parser = &Parser{}
+	}
+	seq, errs := parser.ParseFile(fs, filename)
+	seq, passErrs := pipeline.Run(seq)
+	return seq, append(errs, passErrs...)
+}
+
+// Run runs the passes on seq, all passes run even when some of them fail
+func (pipeline *Pipeline) Run(seq Sequence) (Sequence, []error) {
+	var errs []error
+	for _, pass := range pipeline.Passes {
+		var passErrs []error
+		seq, passErrs = pass(seq)
+		errs = append(errs, passErrs...)
+	}
+	return seq, errs
+}
+
+// ShiftHeadings changes the level of all headings by delta,
+// levels are kept between 1 and 6.
+func ShiftHeadings(delta int) Pass {
+	return func(seq Sequence) (Sequence, []error) {
+		Inspect(seq, func(node Node) bool {
+			if sec, ok := node.(*Section); ok {
+				sec.Level += delta
+				if sec.Level < 1 {
+					sec.Level = 1
+				}
+				if sec.Level > 6 {
+					sec.Level = 6
+				}
+			}
+			return true
+		})
+		return seq, nil
+	}
+}
+
+// RewriteLinks replaces the targets of links and images with the result of fn
+func RewriteLinks(fn func(href string) string) Pass {
+	return func(seq Sequence) (Sequence, []error) {
+		seq = Apply(seq, nil, func(c *Cursor) bool {
+			switch node := c.Node().(type) {
+			case Link:
+				node.Href = fn(node.Href)
+				c.Replace(node)
+			case Image:
+				node.Href = fn(node.Href)
+				c.Replace(node)
+			}
+			return true
+		}).(Sequence)
+		return seq, nil
+	}
+}
+
+// StripModifier removes blocks with the `{.class}` modifier,
+// e.g. StripModifier("draft") removes draft content.
+func StripModifier(class string) Pass {
+	return func(seq Sequence) (Sequence, []error) {
+		seq = Apply(seq, func(c *Cursor) bool {
+			if mod, ok := c.Node().(*Modifier); ok && hasClass(mod.Class, class) {
+				c.Delete()
+				return false
+			}
+			return true
+		}, nil).(Sequence)
+		return seq, nil
+	}
+}
+
+// hasClass checks whether space separated classes contain class
+func hasClass(classes, class string) bool {
+	for _, c := range strings.Fields(classes) {
+		if c == class {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,115 @@
+package mark
+
+import "fmt"
+
+// Location is a point in a source file
+type Location struct {
+	Line   int // 1-based line number
+	Column int // 1-based column in bytes
+	Offset int // 0-based byte offset
+}
+
+// Position is the source range of a node, End is exclusive.
+//
+// Nodes from included files refer to the included file. Nodes created from
+// variables or directives refer to the line where they were used.
+//
+// IncludedBy is set on the outermost blocks that a directive brought in
+// from another file, it refers to the directive line of the including file.
+type Position struct {
+	Path  string // relative to FileSystem root
+	Start Location
+	End   Location
+
+	IncludedBy *Invocation
+}
+
+// Invocation is a directive line, such as `{{chapter.md#intro level=+1}}`
+type Invocation struct {
+	Position
+	Name string // name of the directive, "include" for paths
+	Args string
+	Text string // the line as written
+}
+
+// Pos returns the position of the node
+func (pos Position) Pos() Position { return pos }
+
+// IsValid checks whether the position has been set
+func (pos Position) IsValid() bool { return pos.Start.Line > 0 }
+
+func (pos Position) String() string {
+	if !pos.IsValid() {
+		return pos.Path
+	}
+	return fmt.Sprintf("%s:%d:%d", pos.Path, pos.Start.Line, pos.Start.Column)
+}
+
+// position allows updating the embedded position of block nodes
+func (pos *Position) position() *Position { return pos }
+
+// setPosition sets the position of node when it hasn't been set
+func setPosition(node interface{}, pos Position) {
+	if p, ok := node.(interface{ position() *Position }); ok && !p.position().IsValid() {
+		*p.position() = pos
+	}
+}
+
+// location returns the location of offset in content
+func (rd *reader) location(offset int) Location {
+	if rd.lineStarts == nil {
+		rd.lineStarts = []int{0}
+		for i := 0; i < len(rd.content); i++ {
+			c := rd.content[i]
+			if c != '\r' && c != '\n' {
+				continue
+			}
+			if i+1 < len(rd.content) && (rd.content[i+1] == '\r' || rd.content[i+1] == '\n') && rd.content[i+1] != c {
+				i++
+			}
+			rd.lineStarts = append(rd.lineStarts, i+1)
+		}
+	}
+
+	line := 0
+	for low, high := 0, len(rd.lineStarts); low < high; {
+		mid := (low + high) / 2
+		if rd.lineStarts[mid] <= offset {
+			line, low = mid, mid+1
+		} else {
+			high = mid
+		}
+	}
+	return Location{
+		Line:   line + 1,
+		Column: offset - rd.lineStarts[line] + 1,
+		Offset: offset,
+	}
+}
+
+// lineStop returns the offset of the line ending of the current line
+func (rd *reader) lineStop() int {
+	for i := rd.head.start; i < len(rd.content); i++ {
+		if rd.content[i] == '\r' || rd.content[i] == '\n' {
+			return i
+		}
+	}
+	return len(rd.content)
+}
+
+// position returns the position of content between offsets
+func (parse *parse) position(from, to int) Position {
+	if parse.virtual && parse.parent != nil {
+		return parse.parent.linePosition()
+	}
+	return Position{
+		Path:  parse.path,
+		Start: parse.reader.location(from),
+		End:   parse.reader.location(to),
+	}
+}
+
+// linePosition returns the position of the current line
+func (parse *parse) linePosition() Position {
+	return parse.position(parse.reader.head.begin, parse.reader.lineStop())
+}
@@ -10,6 +10,8 @@ type reader struct {
 	prefixes []prefix
 	content  string
 	head     span
+
+	lineStarts []int // offsets of lines in content, see location
 }
 
 type prefix struct {
@@ -210,7 +212,9 @@ func (line line) StartsTitle() bool {
 	for i, r := range line.trim3() {
 		if r != '#' {
 			if !unicode.IsSpace(r) {
-				return false
+				// `##Title` is reported as a heading without a space,
+				// a single `#` is likely a hashtag
+				return 2 <= i && i <= 6
 			}
 			return 1 <= i
 		}
@@ -218,6 +222,47 @@ func (line line) StartsTitle() bool {
 	return false
 }
 
+// StartsFence checks for at least three backticks or tildes,
+// info string after backticks must not contain backticks.
+func (line line) StartsFence() bool {
+	trimmed := line.trim3()
+	if len(trimmed) < 3 || trimmed[0] == ' ' {
+		return false
+	}
+	fencechar := trimmed[0]
+	if fencechar != '`' && fencechar != '~' {
+		return false
+	}
+	size := 0
+	for size < len(trimmed) && trimmed[size] == fencechar {
+		size++
+	}
+	if size < 3 {
+		return false
+	}
+	return fencechar == '~' || !strings.Contains(trimmed[size:], "`")
+}
+
+// IsClosingFence checks for a fence with at least size fencechars
+// followed only by spaces.
+func (line line) IsClosingFence(fencechar rune, size int) bool {
+	trimmed := line.trim3()
+	if trimmed == "" || trimmed[0] == ' ' {
+		return false
+	}
+	count := 0
+	for count < len(trimmed) && rune(trimmed[count]) == fencechar {
+		count++
+	}
+	return count >= size && strings.TrimRight(trimmed[count:], " \t") == ""
+}
+
+func (line line) StartsConditional() bool {
+	trimmed := strings.TrimRight(line.trim3(), " \t")
+	return strings.HasPrefix(trimmed, "{if ") && strings.HasSuffix(trimmed, "}") ||
+		trimmed == "{else}" || trimmed == "{end}"
+}
+
 func (line line) ContainsOnly(r rune) bool {
 	foundspace := false
 	for i, x := range line.trim3() {
@@ -0,0 +1,274 @@
+// Package report serializes diagnostics returned by the parser
+// for continuous integration and code review tools.
+package report
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"unicode/utf8"
+
+	"github.com/loov/mark"
+)
+
+// Diagnostic is the serialized form of an error
+type Diagnostic struct {
+	Path      string   `json:"path,omitempty"`
+	Line      int      `json:"line,omitempty"`
+	Column    int      `json:"column,omitempty"`
+	EndColumn int      `json:"endColumn,omitempty"`
+	Severity  string   `json:"severity"`
+	Code      string   `json:"code,omitempty"`
+	Message   string   `json:"message"`
+	Stack     []string `json:"stack,omitempty"`
+
+	Suppressed bool `json:"suppressed,omitempty"`
+}
+
+// Convert converts err into a Diagnostic, errors other than
+// *mark.ParseError only contain the message.
+func Convert(err error) Diagnostic {
+	var diag *mark.ParseError
+	if !errors.As(err, &diag) {
+		return Diagnostic{
+			Severity: mark.SeverityError.String(),
+			Message:  err.Error(),
+		}
+	}
+
+	r := Diagnostic{
+		Path:      diag.Path,
+		Line:      diag.Line,
+		Column:    diag.Column,
+		EndColumn: diag.EndColumn,
+		Severity:  diag.Severity.String(),
+		Code:      string(diag.Code),
+		Message:   diag.Err.Error(),
+
+		Suppressed: diag.Suppressed,
+	}
+	for _, frame := range diag.Stack {
+		r.Stack = append(r.Stack, frame.String())
+	}
+	return r
+}
+
+// HasErrors checks whether errs contain anything more severe than a warning,
+// suppressed diagnostics are ignored.
+func HasErrors(errs []error) bool {
+	for _, err := range errs {
+		var diag *mark.ParseError
+		if !errors.As(err, &diag) {
+			return true
+		}
+		if diag.Severity == mark.SeverityError && !diag.Suppressed {
+			return true
+		}
+	}
+	return false
+}
+
+// Counts is the number of diagnostics by severity
+type Counts struct {
+	Errors     int
+	Warnings   int
+	Infos      int
+	Suppressed int // not included in the other counts
+}
+
+// Count counts errs by severity, errors other than
+// *mark.ParseError are counted as errors.
+func Count(errs []error) Counts {
+	var counts Counts
+	for _, err := range errs {
+		var diag *mark.ParseError
+		switch {
+		case !errors.As(err, &diag):
+			counts.Errors++
+		case diag.Suppressed:
+			counts.Suppressed++
+		case diag.Severity == mark.SeverityWarning:
+			counts.Warnings++
+		case diag.Severity == mark.SeverityInfo:
+			counts.Infos++
+		default:
+			counts.Errors++
+		}
+	}
+	return counts
+}
+
+func (counts Counts) String() string {
+	return fmt.Sprintf("%d errors, %d warnings, %d infos, %d suppressed",
+		counts.Errors, counts.Warnings, counts.Infos, counts.Suppressed)
+}
+
+// JSONLines writes each error as a JSON object on a separate line
+func JSONLines(w io.Writer, errs []error) error {
+	enc := json.NewEncoder(w)
+	for _, err := range errs {
+		if err := enc.Encode(Convert(err)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SARIF writes errs as a SARIF 2.1.0 log,
+// https://docs.oasis-open.org/sarif/sarif/v2.1.0/sarif-v2.1.0.html
+//
+// Columns are counted in unicode code points instead of bytes.
+func SARIF(w io.Writer, errs []error) error {
+	run := sarifRun{Results: []sarifResult{}, ColumnKind: "unicodeCodePoints"}
+	run.Tool.Driver.Name = "mark"
+	run.Tool.Driver.InformationURI = "https://github.com/loov/mark"
+
+	rules := map[string]int{}
+	for _, err := range errs {
+		diag := Convert(err)
+		if diag.Code != "" {
+			rules[diag.Code] = 0
+		}
+	}
+	for code := range rules {
+		run.Tool.Driver.Rules = append(run.Tool.Driver.Rules, sarifRule{ID: code})
+	}
+	sort.Slice(run.Tool.Driver.Rules, func(i, k int) bool {
+		return run.Tool.Driver.Rules[i].ID < run.Tool.Driver.Rules[k].ID
+	})
+	for i, rule := range run.Tool.Driver.Rules {
+		rules[rule.ID] = i
+	}
+
+	for _, err := range errs {
+		diag := Convert(err)
+		result := sarifResult{
+			Level:   sarifLevel(diag.Severity),
+			Message: sarifMessage{Text: diag.Message},
+		}
+		if diag.Suppressed {
+			result.Suppressions = []sarifSuppression{{Kind: "inSource"}}
+		}
+		if diag.Code != "" {
+			index := rules[diag.Code]
+			result.RuleID = diag.Code
+			result.RuleIndex = &index
+		}
+		var perr *mark.ParseError
+		isParseError := errors.As(err, &perr)
+		if diag.Path != "" {
+			column, endColumn := diag.Column, diag.EndColumn
+			if isParseError {
+				column = codePointColumn(perr.Source, column)
+				endColumn = codePointColumn(perr.Source, endColumn)
+			}
+			result.Locations = []sarifLocation{sarifLocationOf(diag.Path, diag.Line, column, endColumn)}
+		}
+		if isParseError {
+			for _, frame := range perr.Stack {
+				location := sarifLocationOf(frame.Path, frame.Line, 0, 0)
+				location.Message = &sarifMessage{Text: "included from here"}
+				result.RelatedLocations = append(result.RelatedLocations, location)
+			}
+		}
+		run.Results = append(run.Results, result)
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(sarifLog{
+		Schema:  "https://json.schemastore.org/sarif-2.1.0.json",
+		Version: "2.1.0",
+		Runs:    []sarifRun{run},
+	})
+}
+
+func sarifLevel(severity string) string {
+	switch severity {
+	case mark.SeverityWarning.String():
+		return "warning"
+	case mark.SeverityInfo.String():
+		return "note"
+	}
+	return "error"
+}
+
+// codePointColumn converts 1-based byte column in line to
+// a code point column, columns outside of line are kept as is
+func codePointColumn(line string, column int) int {
+	if column <= 1 || column > len(line)+1 {
+		return column
+	}
+	return utf8.RuneCountInString(line[:column-1]) + 1
+}
+
+func sarifLocationOf(path string, line, column, endColumn int) sarifLocation {
+	var location sarifLocation
+	location.PhysicalLocation.ArtifactLocation.URI = path
+	if line > 0 {
+		location.PhysicalLocation.Region = &sarifRegion{
+			StartLine:   line,
+			StartColumn: column,
+			EndColumn:   endColumn,
+		}
+	}
+	return location
+}
+
+type sarifLog struct {
+	Schema  string     `json:"$schema"`
+	Version string     `json:"version"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool struct {
+		Driver struct {
+			Name           string      `json:"name"`
+			InformationURI string      `json:"informationUri"`
+			Rules          []sarifRule `json:"rules,omitempty"`
+		} `json:"driver"`
+	} `json:"tool"`
+	Results    []sarifResult `json:"results"`
+	ColumnKind string        `json:"columnKind"`
+}
+
+type sarifRule struct {
+	ID string `json:"id"`
+}
+
+type sarifResult struct {
+	RuleID           string             `json:"ruleId,omitempty"`
+	RuleIndex        *int               `json:"ruleIndex,omitempty"`
+	Level            string             `json:"level"`
+	Message          sarifMessage       `json:"message"`
+	Locations        []sarifLocation    `json:"locations,omitempty"`
+	RelatedLocations []sarifLocation    `json:"relatedLocations,omitempty"`
+	Suppressions     []sarifSuppression `json:"suppressions,omitempty"`
+}
+
+type sarifSuppression struct {
+	Kind string `json:"kind"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation struct {
+		ArtifactLocation struct {
+			URI string `json:"uri"`
+		} `json:"artifactLocation"`
+		Region *sarifRegion `json:"region,omitempty"`
+	} `json:"physicalLocation"`
+	Message *sarifMessage `json:"message,omitempty"`
+}
+
+type sarifRegion struct {
+	StartLine   int `json:"startLine"`
+	StartColumn int `json:"startColumn,omitempty"`
+	EndColumn   int `json:"endColumn,omitempty"`
+}
@@ -0,0 +1,183 @@
+package report_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/loov/mark"
+	"github.com/loov/mark/report"
+)
+
+func parse(t *testing.T) []error {
+	fs := mark.VirtualDir{
+		"book.md": "See [x](missing.md).\n\n{{include ch1.md}}",
+		"ch1.md":  "# Chapter\n{{$undefined}}",
+	}
+	_, errs := mark.ParseFile(fs, "book.md")
+	if len(errs) != 2 {
+		t.Fatalf("expected 2 errors, got %v", errs)
+	}
+	return errs
+}
+
+func TestJSONLines(t *testing.T) {
+	errs := append(parse(t), errors.New("plain"))
+
+	var buf bytes.Buffer
+	if err := report.JSONLines(&buf, errs); err != nil {
+		t.Fatal(err)
+	}
+
+	exp := []string{
+		`{"path":"book.md","line":1,"column":9,"endColumn":19,"severity":"warning","code":"missing-file","message":"Cannot find file missing.md: file does not exist"}`,
+		`{"path":"ch1.md","line":2,"column":1,"endColumn":15,"severity":"error","code":"undefined-variable","message":"Undefined variable undefined","stack":["book.md:3"]}`,
+		`{"severity":"error","message":"plain"}`,
+	}
+	got := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if strings.Join(got, "\n") != strings.Join(exp, "\n") {
+		t.Errorf("got\n%s\nexp\n%s", strings.Join(got, "\n"), strings.Join(exp, "\n"))
+	}
+}
+
+func TestSARIF(t *testing.T) {
+	errs := parse(t)
+
+	var buf bytes.Buffer
+	if err := report.SARIF(&buf, errs); err != nil {
+		t.Fatal(err)
+	}
+
+	var log struct {
+		Version string
+		Runs    []struct {
+			Tool struct {
+				Driver struct {
+					Rules []struct{ ID string }
+				}
+			}
+			Results []struct {
+				RuleID    string
+				RuleIndex int
+				Level     string
+				Locations []struct {
+					PhysicalLocation struct {
+						ArtifactLocation struct{ URI string }
+						Region           struct{ StartLine, StartColumn, EndColumn int }
+					}
+				}
+				RelatedLocations []struct{}
+			}
+		}
+	}
+	if err := json.Unmarshal(buf.Bytes(), &log); err != nil {
+		t.Fatal(err)
+	}
+
+	if log.Version != "2.1.0" || len(log.Runs) != 1 {
+		t.Fatalf("invalid log %s", buf.String())
+	}
+	run := log.Runs[0]
+	if len(run.Tool.Driver.Rules) != 2 || len(run.Results) != 2 {
+		t.Fatalf("invalid run %s", buf.String())
+	}
+
+	first, second := run.Results[0], run.Results[1]
+	if first.RuleID != "missing-file" || first.Level != "warning" ||
+		run.Tool.Driver.Rules[first.RuleIndex].ID != first.RuleID {
+		t.Errorf("invalid result %+v", first)
+	}
+	location := first.Locations[0].PhysicalLocation
+	if location.ArtifactLocation.URI != "book.md" || location.Region.StartLine != 1 ||
+		location.Region.StartColumn != 9 || location.Region.EndColumn != 19 {
+		t.Errorf("invalid location %+v", location)
+	}
+	if second.RuleID != "undefined-variable" || second.Level != "error" || len(second.RelatedLocations) != 1 {
+		t.Errorf("invalid result %+v", second)
+	}
+}
+
+func TestSARIFColumns(t *testing.T) {
+	fs := mark.VirtualDir{"book.md": "Vaata [x](missing.md) ja [ü](ü.md)."}
+	_, errs := mark.ParseFile(fs, "book.md")
+
+	var buf bytes.Buffer
+	if err := report.SARIF(&buf, errs); err != nil {
+		t.Fatal(err)
+	}
+
+	var log struct {
+		Runs []struct {
+			ColumnKind string
+			Results    []struct {
+				Locations []struct {
+					PhysicalLocation struct {
+						Region struct{ StartColumn, EndColumn int }
+					}
+				}
+			}
+		}
+	}
+	if err := json.Unmarshal(buf.Bytes(), &log); err != nil {
+		t.Fatal(err)
+	}
+	if len(log.Runs) != 1 || len(log.Runs[0].Results) != 2 || log.Runs[0].ColumnKind != "unicodeCodePoints" {
+		t.Fatalf("invalid log %s", buf.String())
+	}
+	var got []int
+	for _, result := range log.Runs[0].Results {
+		region := result.Locations[0].PhysicalLocation.Region
+		got = append(got, region.StartColumn, region.EndColumn)
+	}
+	if exp := []int{11, 21, 30, 34}; fmt.Sprint(got) != fmt.Sprint(exp) {
+		t.Errorf("got columns %v exp %v", got, exp)
+	}
+}
+
+func TestHasErrors(t *testing.T) {
+	errs := parse(t)
+	if !report.HasErrors(errs) {
+		t.Errorf("expected errors in %v", errs)
+	}
+	if report.HasErrors(errs[:1]) {
+		t.Errorf("warnings should not be errors: %v", errs[:1])
+	}
+}
+
+func TestSuppressed(t *testing.T) {
+	fs := mark.VirtualDir{
+		"book.md": "<!-- mark-ignore undefined-variable -->\n{{$a}}\n\n[x](x.md) {{$b}}",
+	}
+	parser := &mark.Parser{KeepSuppressed: true}
+	_, errs := parser.ParseFile(fs, "book.md")
+
+	counts := report.Count(append(errs, errors.New("plain")))
+	if exp := (report.Counts{Errors: 2, Warnings: 1, Suppressed: 1}); counts != exp {
+		t.Errorf("got %v exp %v", counts, exp)
+	}
+	if !report.HasErrors(errs) {
+		t.Errorf("expected errors in %v", errs)
+	}
+	if report.HasErrors(errs[:1]) {
+		t.Errorf("suppressed diagnostics should not be errors: %v", errs[:1])
+	}
+
+	var buf bytes.Buffer
+	if err := report.JSONLines(&buf, errs[:1]); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), `"suppressed":true`) {
+		t.Errorf("expected suppressed diagnostic, got %s", buf.String())
+	}
+
+	buf.Reset()
+	if err := report.SARIF(&buf, errs[:1]); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), `"kind": "inSource"`) {
+		t.Errorf("expected suppression, got %s", buf.String())
+	}
+}
@@ -8,6 +8,7 @@ import (
 
 	"github.com/loov/mark"
 	"github.com/loov/mark/html"
+	"github.com/loov/mark/internal/marktest"
 )
 
 // Convenience functions
@@ -22,12 +23,12 @@ func Seq(blocks ...mark.Block) mark.Sequence { return mark.Sequence(blocks) }
 func Ul(seqs ...mark.Sequence) *mark.List    { return &mark.List{Ordered: false, Content: seqs} }
 func Ol(seqs ...mark.Sequence) *mark.List    { return &mark.List{Ordered: true, Content: seqs} }
 func Quote(blocks ...mark.Block) *mark.Quote { return &mark.Quote{Content: blocks} }
-func Text(s string) mark.Text                { return mark.Text(s) }
+func Text(s string) mark.Text                { return mark.Text{Value: s} }
 
 func Para(elems ...mark.Inline) *mark.Paragraph { return &mark.Paragraph{Items: elems} }
-func Em(elems ...mark.Inline) mark.Emphasis     { return mark.Emphasis(elems) }
-func Bold(elems ...mark.Inline) mark.Bold       { return mark.Bold(elems) }
-func CodeSpan(s string) mark.CodeSpan           { return mark.CodeSpan(s) }
+func Em(elems ...mark.Inline) mark.Emphasis     { return mark.Emphasis{Items: elems} }
+func Bold(elems ...mark.Inline) mark.Bold       { return mark.Bold{Items: elems} }
+func CodeSpan(s string) mark.CodeSpan           { return mark.CodeSpan{Value: s} }
 
 func Link(href string, title ...mark.Inline) mark.Link {
 	t := Para(title...)
@@ -37,21 +38,28 @@ func Link(href string, title ...mark.Inline) mark.Link {
 	}
 }
 
+func Tooltip(link mark.Link, tooltip string) mark.Link {
+	link.Tooltip = tooltip
+	return link
+}
+
 var SB = mark.SoftBreak{}
 
 func Code(lang string, lines ...string) *mark.Code {
 	return &mark.Code{
 		Language: lang,
+		Info:     lang,
 		Lines:    lines,
 	}
 }
 
 type TestCase struct {
-	In   string
-	Exp  mark.Sequence
-	Skip bool
-	FS   mark.FileSystem
-	Errs []string
+	In     string
+	Exp    mark.Sequence
+	Skip   bool
+	FS     mark.FileSystem
+	Parser *mark.Parser
+	Errs   []string
 }
 
 type TestCases []TestCase
@@ -94,7 +102,11 @@ func (cases TestCases) Run(t *testing.T) {
 
 func (tc *TestCase) Run(br string, i int, t *testing.T) (ok bool) {
 	ok = true
-	out, errs := mark.ParseContent(tc.FS, "main.md", []byte(tc.In))
+	parser := tc.Parser
+	if parser == nil {
+		parser = &mark.Parser{}
+	}
+	out, errs := parser.ParseContent(tc.FS, "main.md", []byte(tc.In))
 
 	sameerr := len(errs) == len(tc.Errs)
 	if sameerr {
@@ -110,6 +122,8 @@ func (tc *TestCase) Run(br string, i int, t *testing.T) (ok bool) {
 		ok = false
 	}
 
+	// positions are tested separately
+	out = marktest.ClearPositions(out)
 	if !reflect.DeepEqual(out, tc.Exp) {
 		outs := strconv.Quote(html.Convert(out))
 		exps := strconv.Quote(html.Convert(tc.Exp))
@@ -0,0 +1,137 @@
+package mark
+
+import "strings"
+
+// Variables are referenced with `{{$name}}`.
+//
+// Inside paragraphs and headings the reference is replaced with the value as
+// plain text, code spans and link destinations and titles are substituted
+// as well. A line containing only the reference is parsed as markdown,
+// similarly to including a file. Fenced and indented code blocks are not
+// substituted, they are shown verbatim.
+//
+// Values are looked up from Parser.Vars and then from the front matter
+// of the current file and the files including it:
+//
+//	---
+//	version: 1.2
+//	---
+
+// frontMatter reads `---` delimited `name: value` lines at the start of the file.
+// Content without a closing `---` before the first blank or other line
+// is not front matter and is parsed as usual.
+func (parse *parse) frontMatter() {
+	reader := parse.reader
+	start := reader.head
+	if !reader.nextLine() {
+		return
+	}
+	if strings.TrimRight(string(reader.line()), " ") != "---" {
+		reader.undoNextLine()
+		return
+	}
+
+	vars := map[string]string{}
+	for reader.nextLine() {
+		line := strings.TrimSpace(string(reader.line()))
+		if line == "---" {
+			parse.vars = vars
+			return
+		}
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		colon := strings.Index(line, ":")
+		if colon < 0 || !isVariableName(strings.TrimSpace(line[:colon])) {
+			break
+		}
+		name := strings.TrimSpace(line[:colon])
+		value := strings.TrimSpace(line[colon+1:])
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+		vars[name] = value
+	}
+	reader.head = start
+}
+
+// lookupVariable finds the value of a variable referenced at pos and records the reference
+func (parse *parse) lookupVariable(name string, pos Position) (string, bool) {
+	parse.book.referenced[name] = true
+	if value, ok := parse.book.Vars[name]; ok {
+		return value, true
+	}
+	for p := parse; p != nil; p = p.parent {
+		if value, ok := p.vars[name]; ok {
+			return value, true
+		}
+	}
+	if !parse.book.listing {
+		parse.diagnose(pos, ErrUndefinedVariable.Errorf("Undefined variable %s", name))
+	}
+	return "", false
+}
+
+// expandVariable parses the value of a variable as markdown
+func (parent *parse) expandVariable(name string) {
+	for p := parent; p != nil; p = p.parent {
+		if p.variable == name {
+			parent.check(ErrRecursiveVariable.Errorf("Cannot recursively expand variable %s", name))
+			return
+		}
+	}
+
+	value, ok := parent.lookupVariable(name, parent.linePosition())
+	if !ok {
+		return
+	}
+
+	child := parent.child(parent.path, value)
+	child.variable = name
+	child.virtual = true
+	child.run()
+
+	parent.mergeBlocks(child.sequence)
+	parent.errors = append(parent.errors, child.errors...)
+}
+
+// blockVariable checks whether line consists only of `{{$name}}`
+func blockVariable(line string) (string, bool) {
+	if !strings.HasPrefix(line, "{{$") || !strings.HasSuffix(line, "}}") {
+		return "", false
+	}
+	name := line[3 : len(line)-2]
+	return name, isVariableName(name)
+}
+
+// variableAt returns the name and length of `{{$name}}` at the start of s
+func variableAt(s string) (name string, size int) {
+	if !strings.HasPrefix(s, "{{$") {
+		return "", 0
+	}
+	end := strings.Index(s, "}}")
+	if end < 0 {
+		return "", 0
+	}
+	name = s[3:end]
+	if !isVariableName(name) {
+		return "", 0
+	}
+	return name, end + 2
+}
+
+func isVariableName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, r := range name {
+		switch {
+		case 'a' <= r && r <= 'z', 'A' <= r && r <= 'Z', '0' <= r && r <= '9':
+		case r == '_' || r == '-' || r == '.':
+		default:
+			return false
+		}
+	}
+	return true
+}
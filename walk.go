@@ -0,0 +1,171 @@
+package mark
+
+import "errors"
+
+// Node is a Block, an Inline or a Note
+type Node interface {
+	Pos() Position
+}
+
+// Visitor is called for each node by Walk. When the result w is not nil,
+// Walk visits each of the children of node with w, followed by w.Visit(nil).
+type Visitor interface {
+	Visit(node Node) (w Visitor)
+}
+
+// Walk traverses nodes in depth-first order. It starts by calling
+// v.Visit(node), node must not be nil.
+//
+// Children are visited in source order:
+//
+//	Sequence, *Sequence   blocks
+//	*Section              &Title, Content blocks, &Notes[i]
+//	*Note                 Content blocks
+//	*Quote                &Title when not empty, Content blocks
+//	*Modifier             Content blocks
+//	*List                 &Content[i] for each item
+//	*Separator            &Title when not empty
+//	*Paragraph            Items
+//	Emphasis, Bold        Items
+//	Link                  &Title
+//	Image                 &Alt
+//	InlineModifier        Inline
+//
+// Other nodes, including custom nodes, don't have children.
+// Paragraphs of inline nodes point to copies, because inlines are values.
+func Walk(v Visitor, node Node) {
+	if v = v.Visit(node); v == nil {
+		return
+	}
+	eachChild(node, func(child Node) bool {
+		Walk(v, child)
+		return true
+	})
+	v.Visit(nil)
+}
+
+type inspector func(Node) bool
+
+func (f inspector) Visit(node Node) Visitor {
+	if f(node) {
+		return f
+	}
+	return nil
+}
+
+// Inspect traverses nodes in depth-first order, see Walk. It starts by
+// calling f(node), when f returns true, Inspect invokes f recursively
+// for each of the children of node, followed by a call of f(nil).
+func Inspect(node Node, f func(Node) bool) {
+	Walk(inspector(f), node)
+}
+
+// Results of WalkFunc that change the traversal
+var (
+	SkipChildren = errors.New("skip children")
+	Stop         = errors.New("stop walking")
+)
+
+// WalkFunc is called by WalkPath for each node with its ancestors,
+// outermost first. The parents slice is reused between calls.
+//
+// Returning SkipChildren skips the children of node, returning Stop or
+// any other error stops the traversal.
+type WalkFunc func(node Node, parents []Node) error
+
+// WalkPath traverses nodes in depth-first order, see Walk. The result is
+// the error returned by fn, except for Stop and SkipChildren.
+func WalkPath(node Node, fn WalkFunc) error {
+	err := walkPath(node, nil, fn)
+	if err == Stop || err == SkipChildren {
+		return nil
+	}
+	return err
+}
+
+func walkPath(node Node, parents []Node, fn WalkFunc) error {
+	if err := fn(node, parents); err != nil {
+		if err == SkipChildren {
+			return nil
+		}
+		return err
+	}
+
+	parents = append(parents, node)
+	var err error
+	eachChild(node, func(child Node) bool {
+		err = walkPath(child, parents, fn)
+		return err == nil
+	})
+	return err
+}
+
+// eachChild calls fn for the children of node until fn returns false
+func eachChild(node Node, fn func(Node) bool) bool {
+	blocks := func(seq Sequence) bool {
+		for _, block := range seq {
+			if !fn(block) {
+				return false
+			}
+		}
+		return true
+	}
+	inlines := func(items []Inline) bool {
+		for _, item := range items {
+			if !fn(item) {
+				return false
+			}
+		}
+		return true
+	}
+
+	switch node := node.(type) {
+	case Sequence:
+		return blocks(node)
+	case *Sequence:
+		return blocks(*node)
+	case *Section:
+		if !fn(&node.Title) || !blocks(node.Content) {
+			return false
+		}
+		for i := range node.Notes {
+			if !fn(&node.Notes[i]) {
+				return false
+			}
+		}
+	case *Note:
+		return blocks(node.Content)
+	case *Quote:
+		if !node.Title.IsEmpty() && !fn(&node.Title) {
+			return false
+		}
+		return blocks(node.Content)
+	case *Modifier:
+		return blocks(node.Content)
+	case *List:
+		for i := range node.Content {
+			if !fn(&node.Content[i]) {
+				return false
+			}
+		}
+	case *Separator:
+		if !node.Title.IsEmpty() {
+			return fn(&node.Title)
+		}
+	case *Paragraph:
+		return inlines(node.Items)
+	case Emphasis:
+		return inlines(node.Items)
+	case Bold:
+		return inlines(node.Items)
+	case Link:
+		return fn(&node.Title)
+	case Image:
+		return fn(&node.Alt)
+	case InlineModifier:
+		if node.Inline != nil {
+			return fn(node.Inline)
+		}
+	}
+	return true
+}
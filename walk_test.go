@@ -0,0 +1,135 @@
+package mark_test
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/loov/mark"
+)
+
+const walkContent = "# Title *em*\n\nSee [**x**](x.md) and ![alt](y.png).\n\n> quoted\n\n* a\n\n```\ncode\n```"
+
+func nodeName(node mark.Node) string {
+	return strings.TrimPrefix(strings.TrimPrefix(fmt.Sprintf("%T", node), "*"), "mark.")
+}
+
+func TestWalkPath(t *testing.T) {
+	seq, errs := mark.ParseContent(mark.VirtualDir{"x.md": "", "y.png": ""}, "main.md", []byte(walkContent))
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+
+	var got []string
+	err := mark.WalkPath(seq, func(node mark.Node, parents []mark.Node) error {
+		got = append(got, strings.Repeat(" ", len(parents))+nodeName(node))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exp := []string{
+		"Sequence",
+		" Section",
+		"  Paragraph",
+		"   Text",
+		"   Emphasis",
+		"    Text",
+		"  Paragraph",
+		"   Text",
+		"   Link",
+		"    Paragraph",
+		"     Bold",
+		"      Text",
+		"   Text",
+		"   Image",
+		"    Paragraph",
+		"     Text",
+		"   Text",
+		"  Quote",
+		"   Paragraph",
+		"    Text",
+		"  List",
+		"   Sequence",
+		"    Paragraph",
+		"     Text",
+		"  Code",
+	}
+	if strings.Join(got, "\n") != strings.Join(exp, "\n") {
+		t.Errorf("got\n%s\nexp\n%s", strings.Join(got, "\n"), strings.Join(exp, "\n"))
+	}
+}
+
+func TestWalkSkipAndStop(t *testing.T) {
+	seq, _ := mark.ParseContent(mark.VirtualDir{"x.md": "", "y.png": ""}, "main.md", []byte(walkContent))
+
+	var got []string
+	err := mark.WalkPath(seq, func(node mark.Node, parents []mark.Node) error {
+		got = append(got, nodeName(node))
+		switch node.(type) {
+		case *mark.Paragraph:
+			return mark.SkipChildren
+		case *mark.Quote:
+			return mark.Stop
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := "Sequence Section Paragraph Paragraph Quote"
+	if strings.Join(got, " ") != exp {
+		t.Errorf("got %q exp %q", strings.Join(got, " "), exp)
+	}
+
+	failed := errors.New("failed")
+	err = mark.WalkPath(seq, func(node mark.Node, parents []mark.Node) error {
+		if _, ok := node.(mark.Image); ok {
+			return failed
+		}
+		return nil
+	})
+	if err != failed {
+		t.Errorf("expected %v, got %v", failed, err)
+	}
+}
+
+func TestInspect(t *testing.T) {
+	seq, _ := mark.ParseContent(mark.VirtualDir{"x.md": "", "y.png": ""}, "main.md", []byte(walkContent))
+
+	var texts []string
+	depth, maxDepth := 0, 0
+	mark.Inspect(seq, func(node mark.Node) bool {
+		if node == nil {
+			depth--
+			return false
+		}
+		if _, ok := node.(*mark.Section); ok {
+			// skip the title
+			for _, block := range node.(*mark.Section).Content {
+				mark.Inspect(block, func(node mark.Node) bool {
+					if text, ok := node.(mark.Text); ok {
+						texts = append(texts, text.Value)
+					}
+					return true
+				})
+			}
+			return false
+		}
+		depth++
+		if depth > maxDepth {
+			maxDepth = depth
+		}
+		return true
+	})
+
+	exp := "See |x| and |alt|.|quoted|a"
+	if got := strings.Join(texts, "|"); got != exp {
+		t.Errorf("got %q exp %q", got, exp)
+	}
+	if depth != 0 || maxDepth != 1 {
+		t.Errorf("unbalanced Visit(nil) calls: depth %d, max %d", depth, maxDepth)
+	}
+}